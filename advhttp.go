@@ -2,7 +2,6 @@ package advhttp
 
 import (
 	"fmt"
-	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -16,15 +15,21 @@ func init() {
 	DefaultCors.ExposeHeaders = CorsDefaultExposeHeaders
 	DefaultCors.MaxAge = CorsDefaultMaxAge
 	DefaultCors.AllowCredentials = CorsDefaultAllowCredentials
+	DefaultCors.Reject = CorsDefaultReject
 
 	DefaultHsts = new(Hsts)
 	DefaultHsts.MaxAge = HstsDefaultMaxAge
 	DefaultHsts.IncludeSubDomains = HstsDefaultIncludeSubDomains
 	DefaultHsts.Preload = HstsDefaultPreload
+
+	DefaultTrustedProxies, _ = NewTrustedProxies(TrustedProxiesDefaultCIDRs...)
+
+	DefaultMetrics = NewMetrics()
 }
 
+// Returns the log line for the request in the Apache combined log format
 func LogApache(trw *ResponseWriter, r *http.Request) string {
-	return logWithOptions(trw, r, false, 0)
+	return logFormatCombined.Format(trw, r, 0) + "\n"
 }
 
 func LogCommonExtended(trw *ResponseWriter, r *http.Request) string {
@@ -40,54 +45,24 @@ func LogWithOptions(trw *ResponseWriter, r *http.Request, useXForwarded bool, du
 }
 
 func logWithOptions(trw *ResponseWriter, r *http.Request, useXForwarded bool, duration time.Duration) string {
-	remoteAddr := r.RemoteAddr
-	if r.Header.Get("X-Forwarded-For") != "" && useXForwarded {
-		if fwds := strings.Split(r.Header.Get("X-Forwarded-For"), ","); len(fwds) > 0 {
-			remoteAddr = strings.TrimSpace(fwds[0])
-		}
-	}
-	if remoteHost, _, err := net.SplitHostPort(remoteAddr); err == nil {
-		remoteAddr = remoteHost
-	}
-	if remoteAddr == "" {
-		remoteAddr = "-"
-	}
-	proto := "http"
-	if r.TLS != nil {
-		proto = "https"
-	}
-	if r.Header.Get("X-Forwarded-Proto") != "" && useXForwarded {
-		proto = r.Header.Get("X-Forwarded-Proto")
-	}
-	host := r.Host
-	if r.Header.Get("X-Forwarded-Host") != "" && useXForwarded {
-		host = r.Header.Get("X-Forwarded-Host")
-	}
-	if host == "" {
-		host = "-"
-	}
-	method := r.Method
-	userId := r.Header.Get("X-User-Id")
-	if userId == "" {
-		userId = "-"
-	}
-	clientId := r.Header.Get("X-Client-Id")
-	if clientId == "" {
-		clientId = "-"
-	}
+	entry := NewLogEntry(trw, r, useXForwarded, duration)
 	dur := "-"
 	if duration != 0 {
 		dur = duration.String()
 	}
-	referer := r.Referer()
-	if referer == "" {
-		referer = "-"
+	line := fmt.Sprintf("%v %v %v [%v] %v %v \"%v %v %v\" %v %v %v \"%v\" \"%v\"", orDash(entry.RemoteAddr), orDash(entry.ClientId), orDash(entry.UserId), entry.Time.Format(time.RFC3339Nano), entry.Scheme, orDash(entry.Host), entry.Method, escapeLogValue(entry.URI), entry.Proto, entry.Status, entry.Length, dur, escapeLogValue(orDash(entry.Referer)), escapeLogValue(orDash(entry.UserAgent)))
+	// Traced requests get the trace id on the end so they can be correlated
+	if entry.TraceId != "" {
+		line += " " + entry.TraceId
 	}
-	userAgent := r.UserAgent()
-	if userAgent == "" {
-		userAgent = "-"
+	return line + "\n"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
 	}
-	return fmt.Sprintf("%v %v %v [%v] %v %v \"%v %v %v\" %v %v %v \"%v\" \"%v\"\n", remoteAddr, clientId, userId, time.Now().UTC().Format(time.RFC3339Nano), proto, host, method, r.URL.String(), r.Proto, trw.status, trw.length, dur, referer, userAgent)
+	return s
 }
 
 // BearerAuth is a function that will pull an access token out of the Authorization header
@@ -107,24 +82,9 @@ func BearerAuth(r *http.Request) (bearerToken string, ok bool) {
 }
 
 // This function will take in the accept header string from a inbound request and determine if
-// application/json is an acceptable response for the request. It ignores any priorities that
-// the requester has, and if they don't include an accept header it will treat it as if they
-// had just used Accept: */*
+// application/json is an acceptable response for the request. If they don't include an accept
+// header it will treat it as if they had just used Accept: */*. Use NegotiateContentType to pick
+// the best of several types.
 func IsJSONAnAcceptableResponse(acceptHeader string) bool {
-	//No accept header (or empty) means */*
-	if acceptHeader == "" {
-		return true
-	}
-
-	//Since the browser can use something like this: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8
-	//Acceptable content types are application/json application/* and */*
-	for _, ent := range strings.Split(acceptHeader, ",") {
-		parts := strings.Split(ent, ";")
-		if len(parts) >= 1 {
-			if parts[0] == "application/json" || parts[0] == "application/*" || parts[0] == "*/*" {
-				return true
-			}
-		}
-	}
-	return false
+	return NegotiateContentType(acceptHeader, "application/json") != ""
 }
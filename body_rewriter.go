@@ -0,0 +1,439 @@
+package advhttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	bodyRewriteNone = iota
+	bodyRewriteHTML
+	bodyRewriteCSS
+	bodyRewriteJSON
+)
+
+var (
+	// The most bytes the body rewriter will hold back waiting for the end of a
+	// tag or css rule before rewriting what it has anyway
+	BodyRewriterMaxHoldBack = 64 * 1024
+
+	htmlAttrRegexp  = regexp.MustCompile(`(?i)(\s(href|src|srcset|action|formaction|poster|data|background|cite)\s*=\s*)("[^"]*"|'[^']*'|[^\s>"']+)`)
+	cssURLRegexp    = regexp.MustCompile(`(?i)(url\(\s*['"]?)([^'")\s]*)(['"]?\s*\))`)
+	cssImportRegexp = regexp.MustCompile(`(?i)(@import\s+['"])([^'"]*)(['"])`)
+)
+
+// The BodyRewriter is a UtilResponseWriter that, in addition to re-writing the
+// headers like NewRPResponseWriter, re-writes urls in html, css and json bodies
+// as they are streamed through it. Root relative paths, and absolute urls for
+// any of the Origins, are re-written to include the gateway Prefix. In html the
+// url attributes (href, src, etc) and css url() values are re-written, in css
+// url() and @import values, and in json any string value that starts with a /
+// or an origin. Gzip encoded bodies are decoded and re-encoded, bodies with any
+// other encoding are left alone.
+//
+// Close must be called once the response is done to write out the remainder
+// of the body.
+type BodyRewriter struct {
+	*UtilResponseWriter
+	// The gateway prefix to add to paths
+	Prefix string
+	// Absolute urls starting with these origins (eg http://www.google.com) are
+	// made relative to the gateway
+	Origins []string
+
+	mode    int
+	gzipped bool
+	held    []byte
+	json    jsonRewriteState
+
+	pw    *io.PipeWriter
+	done  chan error
+	gzout lockedBuffer
+	err   error
+}
+
+// The re-encoded gzip output waiting to be written out. The gunzip goroutine
+// writes to it and the handler's goroutine drains it, so that only the
+// handler's goroutine ever touches the underlying writer.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(p []byte) (int, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.Write(p)
+}
+
+// Returns, and empties, the buffered output
+func (lb *lockedBuffer) take() []byte {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if lb.buf.Len() == 0 {
+		return nil
+	}
+	b := append([]byte(nil), lb.buf.Bytes()...)
+	lb.buf.Reset()
+	return b
+}
+
+// Creates a new BodyRewriter wrapping the given http.ResponseWriter that will
+// re-write headers and bodies for the given gateway prefix.
+func NewBodyRewriter(w http.ResponseWriter, prefix string, via string, origins ...string) *BodyRewriter {
+	br := &BodyRewriter{Prefix: prefix, Origins: origins}
+	hcb := NewReverseProxyHeadersCallback(prefix, via)
+	br.UtilResponseWriter = NewUtilResponseWriter(w, func(headers http.Header) {
+		hcb(headers)
+		br.prepare(headers)
+	})
+	return br
+}
+
+// Decides from the response headers whether, and how, the body gets re-written
+func (br *BodyRewriter) prepare(headers http.Header) {
+	br.mode = bodyRewriteNone
+	mediaType, _, _ := mime.ParseMediaType(headers.Get("Content-Type"))
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		br.mode = bodyRewriteHTML
+	case mediaType == "text/css":
+		br.mode = bodyRewriteCSS
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		br.mode = bodyRewriteJSON
+	}
+	switch strings.ToLower(headers.Get("Content-Encoding")) {
+	case "", "identity":
+		br.gzipped = false
+	case "gzip":
+		br.gzipped = true
+	default:
+		br.mode = bodyRewriteNone
+	}
+	if br.mode != bodyRewriteNone {
+		headers.Del("Content-Length")
+	}
+}
+
+func (br *BodyRewriter) Write(p []byte) (int, error) {
+	if !br.sentHeaders {
+		br.WriteHeader(http.StatusOK)
+	}
+	if br.mode == bodyRewriteNone {
+		return br.UtilResponseWriter.Write(p)
+	}
+	if br.err != nil {
+		return 0, br.err
+	}
+	if !br.gzipped {
+		if err := br.process(p, br.UtilResponseWriter, false); err != nil {
+			br.err = err
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if br.pw == nil {
+		pr, pw := io.Pipe()
+		br.pw = pw
+		br.done = make(chan error, 1)
+		go br.gunzip(pr)
+	}
+	if _, err := br.pw.Write(p); err != nil {
+		br.err = err
+		return 0, err
+	}
+	if err := br.drain(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Writes out whatever the gunzip goroutine has re-encoded so far, then flushes.
+func (br *BodyRewriter) Flush() {
+	if br.drain() != nil {
+		return
+	}
+	br.UtilResponseWriter.Flush()
+}
+
+// Writes out whatever the gunzip goroutine has re-encoded so far
+func (br *BodyRewriter) drain() error {
+	if b := br.gzout.take(); len(b) > 0 {
+		if _, err := br.UtilResponseWriter.Write(b); err != nil {
+			br.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+// Copies src through Write, so the body is still re-written when it is served
+// from a file.
+func (br *BodyRewriter) ReadFrom(src io.Reader) (int64, error) {
+	if !br.sentHeaders {
+		br.WriteHeader(http.StatusOK)
+	}
+	if br.mode == bodyRewriteNone {
+		return br.UtilResponseWriter.ReadFrom(src)
+	}
+	return io.Copy(writerOnly{br}, src)
+}
+
+// Decodes the gzipped body coming through the pipe, re-writes it and encodes
+// it again into gzout.
+func (br *BodyRewriter) gunzip(pr *io.PipeReader) {
+	gr, err := gzip.NewReader(pr)
+	if err != nil {
+		pr.CloseWithError(err)
+		br.done <- err
+		return
+	}
+	gw := gzip.NewWriter(&br.gzout)
+	buf := make([]byte, 32*1024)
+	for {
+		n, rerr := gr.Read(buf)
+		if n > 0 {
+			if err = br.process(buf[:n], gw, false); err != nil {
+				break
+			}
+		}
+		if rerr == io.EOF {
+			err = br.process(nil, gw, true)
+			break
+		}
+		if rerr != nil {
+			err = rerr
+			break
+		}
+	}
+	if cerr := gw.Close(); err == nil {
+		err = cerr
+	}
+	pr.CloseWithError(err)
+	br.done <- err
+}
+
+// Writes out the remainder of the body. It must be called after the handler
+// writing the response has returned.
+func (br *BodyRewriter) Close() error {
+	return br.close(nil)
+}
+
+// Stops re-writing the body without writing out the remainder, for when the
+// response was aborted part way through.
+func (br *BodyRewriter) abort() error {
+	return br.close(http.ErrAbortHandler)
+}
+
+func (br *BodyRewriter) close(abort error) error {
+	if abort != nil && br.err == nil {
+		br.err = abort
+	}
+	if br.pw != nil {
+		// Stops the gunzip goroutine, failing it if the body is incomplete
+		if br.err != nil {
+			br.pw.CloseWithError(br.err)
+		} else {
+			br.pw.Close()
+		}
+		err := <-br.done
+		br.pw = nil
+		if br.err == nil {
+			br.err = err
+		}
+		if br.err == nil {
+			br.drain()
+		}
+		return br.err
+	}
+	if br.mode == bodyRewriteNone || br.err != nil {
+		return br.err
+	}
+	if !br.gzipped {
+		br.err = br.process(nil, br.UtilResponseWriter, true)
+	}
+	return br.err
+}
+
+// Re-writes as much of the body as is safe and writes it to out. Any trailing
+// partial tag, rule or string is held back until more data arrives, or final.
+func (br *BodyRewriter) process(p []byte, out io.Writer, final bool) error {
+	if br.mode == bodyRewriteJSON {
+		_, err := out.Write(br.json.rewrite(p, br.rewriteURL, final))
+		return err
+	}
+
+	br.held = append(br.held, p...)
+	boundary := len(br.held) - 1
+	if !final {
+		if br.mode == bodyRewriteHTML {
+			boundary = bytes.LastIndexByte(br.held, '>')
+		} else {
+			boundary = bytes.LastIndexAny(br.held, ";}\n")
+		}
+		if boundary < 0 && len(br.held) > BodyRewriterMaxHoldBack {
+			boundary = len(br.held) - 1
+		}
+	}
+	if boundary < 0 {
+		return nil
+	}
+
+	chunk := br.held[:boundary+1]
+	var rewritten []byte
+	if br.mode == bodyRewriteHTML {
+		rewritten = br.rewriteHTML(chunk)
+	} else {
+		rewritten = br.rewriteCSS(chunk)
+	}
+	br.held = append(br.held[:0], br.held[boundary+1:]...)
+	_, err := out.Write(rewritten)
+	return err
+}
+
+func (br *BodyRewriter) rewriteHTML(b []byte) []byte {
+	b = htmlAttrRegexp.ReplaceAllFunc(b, func(m []byte) []byte {
+		sm := htmlAttrRegexp.FindSubmatch(m)
+		value := string(sm[3])
+		quote := ""
+		if value[0] == '"' || value[0] == '\'' {
+			quote = value[:1]
+			value = value[1 : len(value)-1]
+		}
+		if strings.EqualFold(string(sm[2]), "srcset") {
+			candidates := strings.Split(value, ",")
+			for i, candidate := range candidates {
+				fields := strings.Fields(candidate)
+				if len(fields) > 0 {
+					fields[0] = br.rewriteURL(fields[0])
+					candidates[i] = strings.Join(fields, " ")
+				}
+			}
+			value = strings.Join(candidates, ", ")
+		} else {
+			value = br.rewriteURL(value)
+		}
+		return []byte(string(sm[1]) + quote + value + quote)
+	})
+	return br.rewriteCSS(b)
+}
+
+func (br *BodyRewriter) rewriteCSS(b []byte) []byte {
+	for _, re := range []*regexp.Regexp{cssURLRegexp, cssImportRegexp} {
+		b = re.ReplaceAllFunc(b, func(m []byte) []byte {
+			sm := re.FindSubmatch(m)
+			return []byte(string(sm[1]) + br.rewriteURL(string(sm[2])) + string(sm[3]))
+		})
+	}
+	return b
+}
+
+// Makes absolute urls for one of the origins relative to the gateway, and
+// adds the prefix to root relative urls.
+func (br *BodyRewriter) rewriteURL(u string) string {
+	for _, origin := range br.Origins {
+		origin = strings.TrimSuffix(origin, "/")
+		schemeless := ""
+		if i := strings.Index(origin, "//"); i >= 0 {
+			schemeless = origin[i:]
+		}
+		for _, o := range []string{origin, schemeless} {
+			if o == "" || !strings.HasPrefix(u, o) {
+				continue
+			}
+			rest := u[len(o):]
+			if rest == "" {
+				return prefixPath(br.Prefix, "/")
+			}
+			if strings.ContainsAny(rest[:1], "/?#") {
+				if rest[0] != '/' {
+					rest = "/" + rest
+				}
+				return prefixPath(br.Prefix, rest)
+			}
+		}
+	}
+	return prefixPath(br.Prefix, u)
+}
+
+// Tracks where in a json document the rewriter is, so that string values can
+// be told apart from object keys even when split across writes.
+type jsonRewriteState struct {
+	stack     []byte
+	expectKey bool
+	inString  bool
+	escaped   bool
+	isKey     bool
+	str       []byte
+}
+
+func (js *jsonRewriteState) rewrite(p []byte, rewriteURL func(string) string, final bool) []byte {
+	out := make([]byte, 0, len(p))
+	for _, c := range p {
+		if js.inString {
+			js.str = append(js.str, c)
+			if js.escaped {
+				js.escaped = false
+			} else if c == '\\' {
+				js.escaped = true
+			} else if c == '"' {
+				js.inString = false
+				out = append(out, js.rewriteString(rewriteURL)...)
+				js.str = js.str[:0]
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			js.inString = true
+			js.isKey = js.expectKey && len(js.stack) > 0 && js.stack[len(js.stack)-1] == '{'
+			js.str = append(js.str[:0], c)
+			continue
+		case '{', '[':
+			js.stack = append(js.stack, c)
+			js.expectKey = c == '{'
+		case '}', ']':
+			if len(js.stack) > 0 {
+				js.stack = js.stack[:len(js.stack)-1]
+			}
+			js.expectKey = false
+		case ':':
+			js.expectKey = false
+		case ',':
+			js.expectKey = len(js.stack) > 0 && js.stack[len(js.stack)-1] == '{'
+		}
+		out = append(out, c)
+	}
+	if final && js.inString {
+		out = append(out, js.str...)
+		js.str = js.str[:0]
+	}
+	return out
+}
+
+func (js *jsonRewriteState) rewriteString(rewriteURL func(string) string) []byte {
+	if js.isKey {
+		return js.str
+	}
+	var s string
+	if err := json.Unmarshal(js.str, &s); err != nil || !strings.HasPrefix(s, "/") && !strings.Contains(s, "//") {
+		return js.str
+	}
+	rewritten := rewriteURL(s)
+	if rewritten == s {
+		return js.str
+	}
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(rewritten)
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
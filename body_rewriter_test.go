@@ -0,0 +1,91 @@
+package advhttp
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Chunked gzip responses are flushed by the reverse proxy after every write,
+// while the body is being decoded and re-encoded. Run with -race.
+func TestBodyRewriterGzipChunked(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		for i := 0; i < 50; i++ {
+			io.WriteString(gw, `<p><a href="/page">link</a><img src="/img.png"></p>`+"\n")
+			gw.Flush()
+			w.(http.Flusher).Flush()
+		}
+		gw.Close()
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL)
+	grp := NewGatewayReverseProxy(target, true, "/app")
+	grp.RewriteBody = true
+	gateway := httptest.NewServer(grp)
+	defer gateway.Close()
+
+	req, _ := http.NewRequest("GET", gateway.URL+"/app/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected a gzip response, got %q", resp.Header.Get("Content-Encoding"))
+	}
+	gr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(body), `<a href="/app/page">`); n != 50 {
+		t.Errorf("Expected 50 re-written links, got %d in:\n%s", n, body)
+	}
+	if n := strings.Count(string(body), `<img src="/app/img.png">`); n != 50 {
+		t.Errorf("Expected 50 re-written images, got %d", n)
+	}
+}
+
+// An aborted gzip response must stop the gunzip goroutine without writing out
+// the rest of the body.
+func TestBodyRewriterGzipAbort(t *testing.T) {
+	rec := httptest.NewRecorder()
+	br := NewBodyRewriter(rec, "/app", GatewayDefaultVia)
+	br.Header().Set("Content-Type", "text/html")
+	br.Header().Set("Content-Encoding", "gzip")
+	var body strings.Builder
+	gw := gzip.NewWriter(&body)
+	io.WriteString(gw, `<a href="/page">link</a>`)
+	gw.Flush()
+	br.Write([]byte(body.String()))
+	written := rec.Body.Len()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- br.abort()
+	}()
+	select {
+	case err := <-done:
+		if err != http.ErrAbortHandler {
+			t.Errorf("Expected ErrAbortHandler, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The gunzip goroutine didn't stop")
+	}
+	if rec.Body.Len() != written {
+		t.Errorf("Expected nothing more to be written after the abort")
+	}
+}
@@ -0,0 +1,109 @@
+package advhttp
+
+import (
+	"io"
+	"net/http"
+)
+
+// A Middleware wraps a handler, returning a handler that does something before
+// and/or after calling it.
+type Middleware func(http.Handler) http.Handler
+
+// A Chain is an ordered list of middleware. The first middleware is the
+// outermost, so it sees the request first and the response last:
+//
+//	chain := NewChain(NewRequestIdHandler, LoggingMiddleware(os.Stdout))
+//	chain.Use(NewPanicRecoveryHandler)
+//	http.ListenAndServe(":http", chain.Then(mux))
+type Chain struct {
+	middlewares []Middleware
+}
+
+// Returns a new chain of the given middleware.
+func NewChain(middlewares ...Middleware) *Chain {
+	return &Chain{middlewares: append([]Middleware(nil), middlewares...)}
+}
+
+// Adds the middleware to the end (the inside) of the chain.
+func (c *Chain) Use(middlewares ...Middleware) *Chain {
+	c.middlewares = append(c.middlewares, middlewares...)
+	return c
+}
+
+// Returns a new chain of this chain's middleware followed by the given
+// middleware, leaving this chain unchanged.
+func (c *Chain) Append(middlewares ...Middleware) *Chain {
+	chain := NewChain(c.middlewares...)
+	return chain.Use(middlewares...)
+}
+
+// Returns h wrapped in the chain's middleware. A nil h is http.DefaultServeMux.
+func (c *Chain) Then(h http.Handler) http.Handler {
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		h = c.middlewares[i](h)
+	}
+	return h
+}
+
+// Returns the handler function wrapped in the chain's middleware.
+func (c *Chain) ThenFunc(fn http.HandlerFunc) http.Handler {
+	if fn == nil {
+		return c.Then(nil)
+	}
+	return c.Then(fn)
+}
+
+// Returns a Middleware for NewLoggingHandler writing to log.
+func LoggingMiddleware(log io.Writer) Middleware {
+	return func(h http.Handler) http.Handler {
+		return NewLoggingHandler(h, log)
+	}
+}
+
+// Returns a Middleware for NewFormatLoggingHandler writing to log.
+func FormatLoggingMiddleware(log io.Writer, lf *LogFormat) Middleware {
+	return func(h http.Handler) http.Handler {
+		return NewFormatLoggingHandler(h, log, lf)
+	}
+}
+
+// Returns a Middleware for NewCorsHandler with the cors object.
+func CorsMiddleware(cors *Cors) Middleware {
+	return func(h http.Handler) http.Handler {
+		return NewCorsHandler(h, cors)
+	}
+}
+
+// Returns a Middleware for NewHstsHandler with the hsts object.
+func HstsMiddleware(hsts *Hsts) Middleware {
+	return func(h http.Handler) http.Handler {
+		return NewHstsHandler(h, hsts)
+	}
+}
+
+// Returns the standard chain for a service, logging to log. From the outside
+// in it is:
+//
+//   - request id, so the logs and error responses can include it
+//   - logging, so that it records the 500 for a recovered panic
+//   - hsts, so that it's header is on every response, including rejections
+//   - cors, using the DefaultCors settings
+//   - panic recovery, inside hsts and cors so the headers they set are kept on
+//     the error response
+//
+// Middleware added with Use or Append goes inside panic recovery. The tracing handler has
+// to be outside the logging, so wrap the result with it:
+//
+//	h := NewTracingHandler(DefaultStack(os.Stdout).Then(mux))
+func DefaultStack(log io.Writer) *Chain {
+	return NewChain(
+		NewRequestIdHandler,
+		LoggingMiddleware(log),
+		NewDefaultHstsHandler,
+		NewDefaultCorsHandler,
+		NewPanicRecoveryHandler,
+	)
+}
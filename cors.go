@@ -1,6 +1,7 @@
 package advhttp
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -25,6 +26,10 @@ var (
 	CorsDefaultExposeHeaders    = []string{"Location", "Content-Type", "ETag", "Accept-Patch"}
 	CorsDefaultMaxAge           = int64(1728000)
 	CorsDefaultAllowCredentials = true
+	CorsDefaultReject           = false
+
+	ErrCorsOriginNotAllowed = errors.New("Origin is not allowed")
+	ErrCorsMethodNotAllowed = errors.New("Method is not allowed")
 )
 
 type Cors struct {
@@ -34,6 +39,30 @@ type Cors struct {
 	ExposeHeaders    []string
 	MaxAge           int64
 	AllowCredentials bool
+	// Whether the cors handlers reject requests that fail Check with a 403
+	Reject bool
+}
+
+// Returns an error if the cross origin request isn't allowed, because it's
+// Origin isn't the AllowOrigin (unless that is * or empty), or it is a preflight
+// asking for a method that isn't in AllowMethods.
+func (cors *Cors) Check(r *http.Request) error {
+	origin := r.Header.Get(CorsOrigin)
+	if origin == "" {
+		return nil
+	}
+	if cors.AllowOrigin != "" && cors.AllowOrigin != "*" && origin != cors.AllowOrigin {
+		return ErrCorsOriginNotAllowed
+	}
+	if method := r.Header.Get(CorsAccessControlRequestMethod); r.Method == "OPTIONS" && method != "" {
+		for _, allowed := range cors.AllowMethods {
+			if strings.EqualFold(method, allowed) {
+				return nil
+			}
+		}
+		return ErrCorsMethodNotAllowed
+	}
+	return nil
 }
 
 //This function will write out cross origin headers so that javascript clients can call apis.
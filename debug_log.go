@@ -0,0 +1,135 @@
+package advhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+const (
+	DebugLogRedacted = "[REDACTED]"
+)
+
+var (
+	DebugLogDefaultMaxBodyBytes      = 4096
+	DebugLogDefaultRedactHeaders     = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+	DebugLogDefaultRedactQueryParams = []string{"access_token"}
+)
+
+// The DebugLog records the headers and the start of the bodies of requests and
+// responses, for debugging integrations. Sensitive headers, query params and
+// json fields are redacted. Each request is logged as a json object with the
+// LogEntry fields plus request_headers, response_headers, request_body and
+// response_body.
+type DebugLog struct {
+	// The request headers to record, nil records all of them
+	RequestHeaders []string
+	// The response headers to record, nil records all of them
+	ResponseHeaders []string
+	// The number of bytes of each body to record
+	MaxBodyBytes int
+	// Headers whose values are redacted
+	RedactHeaders []string
+	// Query params (and form fields) whose values are redacted
+	RedactQueryParams []string
+	// Json fields whose values are redacted from bodies, eg password
+	RedactJSONFields []string
+	// Whether to use the Forwarded and X-Forwarded-* headers for ip, host and proto
+	UseXForwarded bool
+}
+
+// Returns a new DebugLog with the default settings.
+func NewDebugLog() *DebugLog {
+	dl := new(DebugLog)
+	dl.MaxBodyBytes = DebugLogDefaultMaxBodyBytes
+	dl.RedactHeaders = DebugLogDefaultRedactHeaders
+	dl.RedactQueryParams = DebugLogDefaultRedactQueryParams
+	return dl
+}
+
+type debugLogRecord struct {
+	*LogEntry
+	RequestHeaders  http.Header `json:"request_headers,omitempty"`
+	ResponseHeaders http.Header `json:"response_headers,omitempty"`
+	RequestBody     string      `json:"request_body,omitempty"`
+	ResponseBody    string      `json:"response_body,omitempty"`
+}
+
+// Formats the request as a json debug record, it is a LogFunc. The bodies are
+// only available if they were captured, which the LoggingHandler does when its
+// Debug field is set.
+func (dl *DebugLog) Format(trw *ResponseWriter, r *http.Request, duration time.Duration) string {
+	record := debugLogRecord{LogEntry: NewLogEntry(trw, r, dl.UseXForwarded, duration)}
+	record.URI = dl.redactURI(r.URL)
+	record.RequestHeaders = dl.headers(r.Header, dl.RequestHeaders)
+	record.ResponseHeaders = dl.headers(trw.Header(), dl.ResponseHeaders)
+	if rc, ok := r.Body.(*ReadCloser); ok {
+		record.RequestBody = dl.redactBody(rc.Captured())
+	}
+	record.ResponseBody = dl.redactBody(trw.Captured())
+	b, err := json.Marshal(record)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+func (dl *DebugLog) headers(h http.Header, names []string) http.Header {
+	out := make(http.Header)
+	if names == nil {
+		for k, v := range h {
+			out[k] = v
+		}
+	} else {
+		for _, name := range names {
+			if v, ok := h[http.CanonicalHeaderKey(name)]; ok {
+				out[http.CanonicalHeaderKey(name)] = v
+			}
+		}
+	}
+	for _, name := range dl.RedactHeaders {
+		if v, ok := out[http.CanonicalHeaderKey(name)]; ok {
+			redacted := make([]string, len(v))
+			for i := range redacted {
+				redacted[i] = DebugLogRedacted
+			}
+			out[http.CanonicalHeaderKey(name)] = redacted
+		}
+	}
+	return out
+}
+
+func (dl *DebugLog) redactURI(u *url.URL) string {
+	ru := *u
+	query := ru.Query()
+	redacted := false
+	for _, param := range dl.RedactQueryParams {
+		if values, ok := query[param]; ok {
+			for i := range values {
+				values[i] = DebugLogRedacted
+			}
+			redacted = true
+		}
+	}
+	if redacted {
+		ru.RawQuery = query.Encode()
+	}
+	return ru.String()
+}
+
+func (dl *DebugLog) redactBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	for _, field := range dl.RedactJSONFields {
+		re := regexp.MustCompile(`("` + regexp.QuoteMeta(field) + `"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`)
+		body = re.ReplaceAll(body, []byte(`${1}"`+DebugLogRedacted+`"`))
+	}
+	for _, param := range dl.RedactQueryParams {
+		re := regexp.MustCompile(`(^|&)(` + regexp.QuoteMeta(url.QueryEscape(param)) + `=)[^&]*`)
+		body = re.ReplaceAll(body, []byte("${1}${2}"+url.QueryEscape(DebugLogRedacted)))
+	}
+	return string(body)
+}
@@ -0,0 +1,151 @@
+package advhttp
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	ForwardedHeader = "Forwarded"
+)
+
+// A ForwardedElement is a single proxy hop from an RFC 7239 Forwarded header.
+// Each field is the unquoted value of the parameter, or empty if the hop didn't
+// include it.
+type ForwardedElement struct {
+	// The client that made the request to the proxy, eg 192.0.2.60 or [2001:db8::1]:4711
+	For string
+	// The interface on the proxy the request came in on
+	By string
+	// The Host header the proxy received
+	Host string
+	// The protocol the proxy received the request with (http or https)
+	Proto string
+}
+
+// Formats the element for use in a Forwarded header, quoting values that need
+// it (ipv6 addresses, ports, etc).
+func (fe ForwardedElement) String() string {
+	pairs := make([]string, 0, 4)
+	for _, pair := range [][2]string{{"for", fe.For}, {"by", fe.By}, {"host", fe.Host}, {"proto", fe.Proto}} {
+		if pair[1] != "" {
+			pairs = append(pairs, pair[0]+"="+quoteForwardedValue(pair[1]))
+		}
+	}
+	return strings.Join(pairs, ";")
+}
+
+// Returns the ip of the For node, without any port or brackets. Obfuscated
+// identifiers (eg _hidden) and unknown are returned as is.
+func (fe ForwardedElement) ForIP() string {
+	return forwardedNodeIP(fe.For)
+}
+
+// Parses all of the Forwarded headers on a request into their elements, the
+// first element being the hop closest to the client.
+func ParseForwarded(h http.Header) []ForwardedElement {
+	elements := make([]ForwardedElement, 0)
+	for _, value := range h[ForwardedHeader] {
+		for _, element := range splitForwarded(value, ',') {
+			var fe ForwardedElement
+			for _, pair := range splitForwarded(element, ';') {
+				i := strings.Index(pair, "=")
+				if i < 0 {
+					continue
+				}
+				v := unquoteForwardedValue(strings.TrimSpace(pair[i+1:]))
+				switch strings.ToLower(strings.TrimSpace(pair[:i])) {
+				case "for":
+					fe.For = v
+				case "by":
+					fe.By = v
+				case "host":
+					fe.Host = v
+				case "proto":
+					fe.Proto = strings.ToLower(v)
+				}
+			}
+			elements = append(elements, fe)
+		}
+	}
+	return elements
+}
+
+// Formats an address (as found in Request.RemoteAddr, or a bare ip) as a
+// Forwarded node, bracketing ipv6 addresses.
+func ForwardedNode(addr string) string {
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]:" + port
+		}
+		return host + ":" + port
+	}
+	if strings.Contains(addr, ":") && !strings.HasPrefix(addr, "[") {
+		return "[" + addr + "]"
+	}
+	return addr
+}
+
+// Adds an element to the end of the request's Forwarded header, folding any
+// prior Forwarded headers into one.
+func AppendForwarded(r *http.Request, fe ForwardedElement) {
+	values := append(r.Header[ForwardedHeader], fe.String())
+	r.Header.Set(ForwardedHeader, strings.Join(values, ", "))
+}
+
+func forwardedNodeIP(node string) string {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+}
+
+// Values that aren't a plain token have to be a quoted-string
+func quoteForwardedValue(v string) string {
+	for _, c := range v {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return `"` + strings.Replace(strings.Replace(v, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+		}
+	}
+	return v
+}
+
+func unquoteForwardedValue(v string) string {
+	if len(v) < 2 || v[0] != '"' || v[len(v)-1] != '"' {
+		return v
+	}
+	b := make([]byte, 0, len(v))
+	for i := 1; i < len(v)-1; i++ {
+		if v[i] == '\\' && i+1 < len(v)-1 {
+			i++
+		}
+		b = append(b, v[i])
+	}
+	return string(b)
+}
+
+// Splits on sep, ignoring any seps within quoted strings
+func splitForwarded(s string, sep byte) []string {
+	parts := make([]string, 0)
+	inQuote, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\' && inQuote:
+			escaped = true
+		case s[i] == '"':
+			inQuote = !inQuote
+		case s[i] == sep && !inQuote:
+			if part := strings.TrimSpace(s[start:i]); part != "" {
+				parts = append(parts, part)
+			}
+			start = i + 1
+		}
+	}
+	if part := strings.TrimSpace(s[start:]); part != "" {
+		parts = append(parts, part)
+	}
+	return parts
+}
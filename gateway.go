@@ -0,0 +1,295 @@
+package advhttp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A GatewayRoute maps requests matching a host and/or path prefix on to an
+// upstream server. Routes can be built by hand or loaded from a JSON or INI
+// route table.
+type GatewayRoute struct {
+	// A name for the route, used in error messages (and the INI section name)
+	Name string `json:"name"`
+	// The host the request must be for, empty matches any host
+	Host string `json:"host"`
+	// The path prefix the request must start with, empty matches everything
+	Prefix string `json:"prefix"`
+	// The upstream url that matched requests are proxied to
+	Upstream string `json:"upstream"`
+	// A list of upstream urls to balance matched requests across
+	Upstreams []string `json:"upstreams"`
+	// The balance policy used with Upstreams, see UpstreamPool
+	Balance string `json:"balance"`
+	// The header used by the consistent hash policy, defaults to the client ip
+	HashHeader string `json:"hash_header"`
+	// Consecutive failures before an upstream is ejected, zero disables
+	MaxFails int `json:"max_fails"`
+	// Seconds an ejected upstream stays out, zero waits for a health check (or
+	// UpstreamPoolDefaultFailTimeout without one)
+	FailTimeout int `json:"fail_timeout"`
+	// The path probed on each upstream, empty disables active health checks
+	HealthCheckPath string `json:"health_check_path"`
+	// Seconds between health checks, defaults to HealthCheckDefaultInterval
+	HealthCheckInterval int `json:"health_check_interval"`
+	// Whether or not to remove the Prefix from the path before going upstream
+	StripPrefix bool `json:"strip_prefix"`
+	// The value added to the Via header, defaults to GatewayDefaultVia
+	Via string `json:"via"`
+	// Whether to re-write urls in html, css and json response bodies
+	RewriteBody bool `json:"rewrite_body"`
+
+	proxy *GatewayReverseProxy
+}
+
+// The Gateway dispatches each request to the route with the best match for it.
+// Routes with a Host take precedence over routes without one, and after that
+// the longest matching Prefix wins. Requests that don't match any route are
+// handed to the NotFoundHandler (or answered with a 404).
+type Gateway struct {
+	NotFoundHandler http.Handler
+
+	mu     sync.RWMutex
+	routes []*GatewayRoute
+}
+
+// Returns a new gateway serving the given routes. An error is returned if any
+// of the routes are invalid.
+func NewGateway(routes []*GatewayRoute) (*Gateway, error) {
+	g := new(Gateway)
+	for _, route := range routes {
+		if err := g.AddRoute(route); err != nil {
+			// Stop the health checks of the routes already added
+			g.Close()
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// Returns a new gateway from a JSON route table. The table is an array of
+// route objects, eg:
+//
+//	[
+//		{"name":"google", "prefix":"/google/", "upstream":"http://www.google.com/", "strip_prefix":true},
+//		{"name":"api", "host":"api.example.com", "upstream":"http://10.0.0.5:8080/"}
+//	]
+func NewGatewayFromJSON(r io.Reader) (*Gateway, error) {
+	var routes []*GatewayRoute
+	if err := json.NewDecoder(r).Decode(&routes); err != nil {
+		return nil, err
+	}
+	return NewGateway(routes)
+}
+
+// Returns a new gateway from an INI route table, the same format used by the
+// oat config file. Each section is a route, keyed like the JSON route table,
+// and unknown keys are an error, eg:
+//
+//	[google]
+//		prefix=/google/
+//		upstream=http://www.google.com/
+//		strip_prefix=true
+//	[api]
+//		host=api.example.com
+//		upstreams=http://10.0.0.5:8080/ http://10.0.0.6:8080/
+//		balance=least_connections
+func NewGatewayFromINI(r io.Reader) (*Gateway, error) {
+	config, order, err := parseINI(r)
+	if err != nil {
+		return nil, err
+	}
+	routes := make([]*GatewayRoute, 0, len(order))
+	for _, name := range order {
+		route := &GatewayRoute{Name: name}
+		for k, v := range config[name] {
+			switch k {
+			case "host":
+				route.Host = v
+			case "prefix":
+				route.Prefix = v
+			case "upstream":
+				route.Upstream = v
+			case "upstreams":
+				route.Upstreams = strings.Fields(v)
+			case "balance":
+				route.Balance = v
+			case "hash_header":
+				route.HashHeader = v
+			case "max_fails":
+				if route.MaxFails, err = strconv.Atoi(v); err != nil {
+					return nil, errors.New("Route " + name + " has an invalid max_fails: " + v)
+				}
+			case "fail_timeout":
+				if route.FailTimeout, err = strconv.Atoi(v); err != nil {
+					return nil, errors.New("Route " + name + " has an invalid fail_timeout: " + v)
+				}
+			case "health_check_path":
+				route.HealthCheckPath = v
+			case "health_check_interval":
+				if route.HealthCheckInterval, err = strconv.Atoi(v); err != nil {
+					return nil, errors.New("Route " + name + " has an invalid health_check_interval: " + v)
+				}
+			case "strip_prefix":
+				if route.StripPrefix, err = strconv.ParseBool(v); err != nil {
+					return nil, errors.New("Route " + name + " has an invalid strip_prefix: " + v)
+				}
+			case "via":
+				route.Via = v
+			case "rewrite_body":
+				if route.RewriteBody, err = strconv.ParseBool(v); err != nil {
+					return nil, errors.New("Route " + name + " has an invalid rewrite_body: " + v)
+				}
+			default:
+				return nil, errors.New("Route " + name + " has an unknown key: " + k)
+			}
+		}
+		routes = append(routes, route)
+	}
+	return NewGateway(routes)
+}
+
+// Adds a route to the gateway. The route can be added while the gateway is
+// serving requests.
+func (g *Gateway) AddRoute(route *GatewayRoute) error {
+	upstreams := route.Upstreams
+	if route.Upstream != "" {
+		upstreams = append([]string{route.Upstream}, upstreams...)
+	}
+	if len(upstreams) == 0 {
+		return errors.New("Route " + route.Name + " has no upstream")
+	}
+	targets := make([]*url.URL, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		target, err := url.Parse(upstream)
+		if err != nil {
+			return err
+		}
+		if target.Scheme == "" || target.Host == "" {
+			return errors.New("Route " + route.Name + " upstream must be an absolute url")
+		}
+		targets = append(targets, target)
+	}
+	if route.Prefix == "" {
+		route.Prefix = "/"
+	}
+	if len(targets) == 1 && route.MaxFails == 0 && route.HealthCheckPath == "" {
+		route.proxy = NewGatewayReverseProxy(targets[0], route.StripPrefix, route.Prefix)
+	} else {
+		pool, err := NewUpstreamPool(route.Balance, targets...)
+		if err != nil {
+			return errors.New("Route " + route.Name + ": " + err.Error())
+		}
+		pool.HashHeader = route.HashHeader
+		pool.MaxFails = route.MaxFails
+		pool.FailTimeout = time.Duration(route.FailTimeout) * time.Second
+		if route.HealthCheckPath != "" {
+			hc := NewHealthCheck()
+			hc.Path = route.HealthCheckPath
+			if route.HealthCheckInterval > 0 {
+				hc.Interval = time.Duration(route.HealthCheckInterval) * time.Second
+			}
+			pool.StartHealthChecks(hc)
+		}
+		route.proxy = NewGatewayPoolReverseProxy(pool, route.StripPrefix, route.Prefix)
+	}
+	if route.Via != "" {
+		route.proxy.Via = route.Via
+	}
+	route.proxy.RewriteBody = route.RewriteBody
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.routes = append(g.routes, route)
+	sort.SliceStable(g.routes, func(i, j int) bool {
+		if (g.routes[i].Host != "") != (g.routes[j].Host != "") {
+			return g.routes[i].Host != ""
+		}
+		return len(g.routes[i].Prefix) > len(g.routes[j].Prefix)
+	})
+	return nil
+}
+
+// Stops the health checks of every route in the gateway.
+func (g *Gateway) Close() error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, route := range g.routes {
+		if route.proxy.Pool != nil {
+			route.proxy.Pool.StopHealthChecks()
+		}
+	}
+	return nil
+}
+
+// Returns the route that would serve the request, or nil if there isn't one.
+func (g *Gateway) Match(r *http.Request) *GatewayRoute {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, route := range g.routes {
+		if route.Host != "" && !strings.EqualFold(route.Host, host) {
+			continue
+		}
+		if hasPathPrefix(r.URL.Path, route.Prefix) || r.URL.Path == strings.TrimSuffix(route.Prefix, "/") {
+			return route
+		}
+	}
+	return nil
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := g.Match(r)
+	if route == nil {
+		if g.NotFoundHandler != nil {
+			g.NotFoundHandler.ServeHTTP(w, r)
+		} else {
+			http.NotFound(w, r)
+		}
+		return
+	}
+	route.proxy.ServeHTTP(w, r)
+}
+
+// Parses the .ini format used by oat into a map of section to key/value pairs,
+// along with the order the sections were found in.
+func parseINI(r io.Reader) (config map[string]map[string]string, order []string, err error) {
+	config = make(map[string]map[string]string)
+	context := ""
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		//If there is a [] on the line, then we've got a new 'context'
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			context = strings.TrimSpace(line[1 : len(line)-1])
+			if _, ok := config[context]; !ok {
+				config[context] = make(map[string]string)
+				order = append(order, context)
+			}
+			continue
+		}
+
+		if i := strings.Index(line, "="); i > 0 && context != "" {
+			config[context][strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	err = scanner.Err()
+	return
+}
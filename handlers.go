@@ -1,37 +1,16 @@
 package advhttp
 
 import (
-	"errors"
-	"fmt"
 	"io"
 	"net/http"
-	"net/url"
-	"time"
 )
 
 // NewPanicRecoveryHandler will wrap a handler in a recover function that will
 // catch any panics that occur, and gracefully (actually return a response) handle
-// the panic by returning a 500 Internal Server Error response with the panic
-// error as the body.
+// the panic by logging it, with it's stack, and returning a generic 500 Internal
+// Server Error response. Use NewPanicRecovery to report panics elsewhere.
 func NewPanicRecoveryHandler(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			var err error
-			r := recover()
-			if r != nil {
-				switch t := r.(type) {
-				case string:
-					err = errors.New(t)
-				case error:
-					err = t
-				default:
-					err = errors.New("Unknown error")
-				}
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-			}
-		}()
-		h.ServeHTTP(w, r)
-	})
+	return NewPanicRecovery(h)
 }
 
 // Returns a handler that wraps the given handler with Cross Origin Resource
@@ -40,16 +19,44 @@ func NewPanicRecoveryHandler(h http.Handler) http.Handler {
 // or alternatively you can create your own cors object and use `NewCorsHandler()`
 func NewDefaultCorsHandler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ProcessCors(w, r)
-		h.ServeHTTP(w, r)
+		serveCors(DefaultCors, h, w, r)
 	})
 }
 
 // Returns a handler with a custom cors object and uses that methods `ProcessCors()`
-// function before calling the wrapped handler.
+// function before calling the wrapped handler. If the cors object has Reject set
+// requests that fail it's `Check()` get a 403 Forbidden error response instead.
 func NewCorsHandler(h http.Handler, cors *Cors) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cors.ProcessCors(w, r)
+		serveCors(cors, h, w, r)
+	})
+}
+
+func serveCors(cors *Cors, h http.Handler, w http.ResponseWriter, r *http.Request) {
+	if cors.Reject {
+		if err := cors.Check(r); err != nil {
+			WriteError(w, r, http.StatusForbidden, err.Error())
+			return
+		}
+	}
+	cors.ProcessCors(w, r)
+	h.ServeHTTP(w, r)
+}
+
+// Returns a handler that adds the Strict-Transport-Security header to https
+// responses using the default settings, see `ProcessHsts()`.
+func NewDefaultHstsHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ProcessHsts(w, r)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Returns a handler with a custom hsts object and uses that methods
+// `ProcessHsts()` function before calling the wrapped handler.
+func NewHstsHandler(h http.Handler, hsts *Hsts) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hsts.ProcessHsts(w, r)
 		h.ServeHTTP(w, r)
 	})
 }
@@ -59,29 +66,46 @@ func NewCorsHandler(h http.Handler, cors *Cors) http.Handler {
 // The Forwarded Variant will utilize the `X-Forwarded-*` headers to log ip, host,
 // and proto.
 func NewForwardedLoggingHandler(h http.Handler, log io.Writer) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		r.Header.Del("X-User-Id")
-		r.Header.Del("X-Client-Id")
-		trw := NewResponseWriter(w)
-		origURI := r.URL.RequestURI()
-		start := time.Now()
-		h.ServeHTTP(trw, r)
-		r.URL, _ = url.Parse(origURI)
-		fmt.Fprintln(log, trw.LogWithOptions(r, true, time.Now().Sub(start)))
-	})
+	return &LoggingHandler{Handler: h, Log: log, Format: CommonExtendedLogFunc(true)}
 }
 
 // Returns a logging handler that wraps the given handler, and logs output to the
 // given io.Writer. The logging format is a variation of the `Common Log Format`.
 func NewLoggingHandler(h http.Handler, log io.Writer) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		r.Header.Del("X-User-Id")
-		r.Header.Del("X-Client-Id")
-		trw := NewResponseWriter(w)
-		origURI := r.URL.RequestURI()
-		start := time.Now()
-		h.ServeHTTP(trw, r)
-		r.URL, _ = url.Parse(origURI)
-		fmt.Fprintln(log, trw.LogWithOptions(r, true, time.Now().Sub(start)))
-	})
+	return &LoggingHandler{Handler: h, Log: log, Format: CommonExtendedLogFunc(true)}
+}
+
+// Returns a logging handler like `NewLoggingHandler()` that only logs the
+// requests the filter allows, see LogFilter.
+func NewFilteredLoggingHandler(h http.Handler, log io.Writer, filter *LogFilter) http.Handler {
+	return &LoggingHandler{Handler: h, Log: log, Format: CommonExtendedLogFunc(true), Filter: filter}
+}
+
+// Returns a logging handler that wraps the given handler, and logs a json
+// object per request to the given io.Writer. See LogEntry for the fields.
+func NewJSONLoggingHandler(h http.Handler, log io.Writer) http.Handler {
+	return &LoggingHandler{Handler: h, Log: log, Format: JSONLogFunc(false)}
+}
+
+// Returns a json logging handler like `NewJSONLoggingHandler()` that will
+// utilize the `Forwarded` and `X-Forwarded-*` headers to log ip, host, and
+// proto.
+func NewForwardedJSONLoggingHandler(h http.Handler, log io.Writer) http.Handler {
+	return &LoggingHandler{Handler: h, Log: log, Format: JSONLogFunc(true)}
+}
+
+// Returns a logging handler that logs a json debug record per request, with the
+// headers and the start of the bodies of the request and response. See DebugLog
+// for what is recorded and redacted.
+func NewDebugLoggingHandler(h http.Handler, log io.Writer, dl *DebugLog) http.Handler {
+	return &LoggingHandler{Handler: h, Log: log, Format: dl.Format, Debug: dl}
+}
+
+// Returns a logging handler that wraps the given handler, and logs output to the
+// given io.Writer using the given LogFormat, eg:
+//
+//	lf, err := NewLogFormat(LogFormatCombined)
+//	lh := NewFormatLoggingHandler(h, os.Stdout, lf)
+func NewFormatLoggingHandler(h http.Handler, log io.Writer, lf *LogFormat) http.Handler {
+	return &LoggingHandler{Handler: h, Log: log, Format: lf.Format}
 }
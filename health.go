@@ -0,0 +1,110 @@
+package advhttp
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var (
+	HealthCheckDefaultPath     = "/"
+	HealthCheckDefaultInterval = 10 * time.Second
+	HealthCheckDefaultTimeout  = 2 * time.Second
+)
+
+// A HealthCheck describes the probe periodically sent to every upstream in a
+// pool. An upstream passes the probe if it responds to a GET of Path with a
+// status below 400 within Timeout.
+type HealthCheck struct {
+	// The path (and query) requested on each upstream
+	Path string
+	// How often each upstream is probed
+	Interval time.Duration
+	// How long to wait for a probe response
+	Timeout time.Duration
+	// The number of consecutive failed probes before an upstream is ejected
+	UnhealthyThreshold int
+	// The number of consecutive passed probes before it is re-admitted
+	HealthyThreshold int
+	// The client used to send the probes, defaults to a client using Timeout
+	Client *http.Client
+}
+
+// Returns a new HealthCheck with the default settings.
+func NewHealthCheck() *HealthCheck {
+	hc := new(HealthCheck)
+	hc.Path = HealthCheckDefaultPath
+	hc.Interval = HealthCheckDefaultInterval
+	hc.Timeout = HealthCheckDefaultTimeout
+	hc.UnhealthyThreshold = 1
+	hc.HealthyThreshold = 1
+	return hc
+}
+
+// Probes a single upstream and returns whether it passed.
+func (hc *HealthCheck) Probe(u *Upstream) bool {
+	ref, err := url.Parse(hc.Path)
+	if err != nil {
+		return false
+	}
+	client := hc.Client
+	if client == nil {
+		client = &http.Client{Timeout: hc.Timeout}
+	}
+	resp, err := client.Get(u.URL.ResolveReference(ref).String())
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < 400
+}
+
+// Starts probing the upstreams in the pool in the background. Upstreams that
+// fail the health check are ejected from the pool until they pass it again.
+// Any health checks already running on the pool are stopped first.
+func (pool *UpstreamPool) StartHealthChecks(hc *HealthCheck) {
+	pool.StopHealthChecks()
+	stop := make(chan struct{})
+	pool.mu.Lock()
+	pool.stop = stop
+	pool.mu.Unlock()
+
+	go func() {
+		passes := make(map[*Upstream]int)
+		fails := make(map[*Upstream]int)
+		ticker := time.NewTicker(hc.Interval)
+		defer ticker.Stop()
+		for {
+			for _, u := range pool.Upstreams() {
+				if hc.Probe(u) {
+					fails[u] = 0
+					passes[u]++
+					if passes[u] >= hc.HealthyThreshold && !u.Healthy() {
+						u.MarkHealthy()
+					}
+				} else {
+					passes[u] = 0
+					fails[u]++
+					if fails[u] >= hc.UnhealthyThreshold && u.Healthy() {
+						u.MarkUnhealthy(0)
+					}
+				}
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stops any health checks running on the pool.
+func (pool *UpstreamPool) StopHealthChecks() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.stop != nil {
+		close(pool.stop)
+		pool.stop = nil
+	}
+}
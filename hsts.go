@@ -24,22 +24,23 @@ type Hsts struct {
 	IncludeSubDomains bool
 	// Signals that the site should be added to the browsers preload list
 	Preload bool
+	// The proxies allowed to tell us the request was https, if nil the
+	// DefaultTrustedProxies are used
+	TrustedProxies *TrustedProxies
 }
 
 func (hsts *Hsts) ProcessHsts(w http.ResponseWriter, r *http.Request) {
-	writeHsts := false
-	if r.TLS != nil {
-		writeHsts = true
-	}
-	if r.Header.Get("X-Forwarded-Proto") != "" {
-		writeHsts = true
+	tp := hsts.TrustedProxies
+	if tp == nil {
+		tp = DefaultTrustedProxies
 	}
+	writeHsts := tp.RealProto(r) == "https"
 	if writeHsts && hsts.IncludeSubDomains && hsts.Preload {
 		w.Header().Set(HstsStrictTransportSecurity, fmt.Sprintf("max-age=%d; %v; %v", hsts.MaxAge, HstsIncludeSubDomains, HstsPreload))
 		return
 	}
 	if writeHsts && hsts.IncludeSubDomains {
-		w.Header().Set(HstsStrictTransportSecurity, fmt.Sprintf("max-age=%d; %d", hsts.MaxAge, HstsIncludeSubDomains))
+		w.Header().Set(HstsStrictTransportSecurity, fmt.Sprintf("max-age=%d; %v", hsts.MaxAge, HstsIncludeSubDomains))
 		return
 	}
 	if writeHsts {
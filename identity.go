@@ -0,0 +1,103 @@
+package advhttp
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// An IdentityProvider tells the logging handlers who made a request. The
+// logging handlers read it from the request context once the request has been
+// served.
+type IdentityProvider interface {
+	UserID() string
+	ClientID() string
+}
+
+// The Identity is the IdentityProvider the logging handlers put on the request
+// context before serving a request. Auth middleware further down the handler
+// stack records who the request was made by on it with SetUserID and
+// SetClientID, without having to use request headers as a side channel.
+type Identity struct {
+	mu       sync.RWMutex
+	userId   string
+	clientId string
+}
+
+func (id *Identity) UserID() string {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	return id.userId
+}
+
+func (id *Identity) ClientID() string {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	return id.clientId
+}
+
+func (id *Identity) SetUserID(userId string) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	id.userId = userId
+}
+
+func (id *Identity) SetClientID(clientId string) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	id.clientId = clientId
+}
+
+type identityContextKey struct{}
+
+// Returns a copy of the context carrying the identity provider.
+func ContextWithIdentity(ctx context.Context, id IdentityProvider) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, id)
+}
+
+// Returns the identity provider on the context, or nil if there isn't one.
+func IdentityFromContext(ctx context.Context) IdentityProvider {
+	id, _ := ctx.Value(identityContextKey{}).(IdentityProvider)
+	return id
+}
+
+// Returns the request with an empty Identity on it's context, unless it already
+// has an identity provider. The logging handlers do this for you.
+func WithIdentity(r *http.Request) *http.Request {
+	if IdentityFromContext(r.Context()) != nil {
+		return r
+	}
+	return r.WithContext(ContextWithIdentity(r.Context(), new(Identity)))
+}
+
+// Records the user that made the request on the request's Identity. It does
+// nothing if the context doesn't have an Identity (see WithIdentity).
+func SetUserID(r *http.Request, userId string) {
+	if id, ok := IdentityFromContext(r.Context()).(*Identity); ok {
+		id.SetUserID(userId)
+	}
+}
+
+// Records the client that made the request on the request's Identity. It does
+// nothing if the context doesn't have an Identity (see WithIdentity).
+func SetClientID(r *http.Request, clientId string) {
+	if id, ok := IdentityFromContext(r.Context()).(*Identity); ok {
+		id.SetClientID(clientId)
+	}
+}
+
+// Returns the user recorded for the request, or empty if there isn't one.
+func UserID(r *http.Request) string {
+	if id := IdentityFromContext(r.Context()); id != nil {
+		return id.UserID()
+	}
+	return ""
+}
+
+// Returns the client recorded for the request, or empty if there isn't one.
+func ClientID(r *http.Request) string {
+	if id := IdentityFromContext(r.Context()); id != nil {
+		return id.ClientID()
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+package advhttp
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+)
+
+// A LogEntry holds the fields of a single access log line. It is filled in from
+// the request and the ResponseWriter once the request has been served, and can
+// then be formatted as text or json.
+type LogEntry struct {
+	Time          time.Time     `json:"time"`
+	RemoteAddr    string        `json:"remote_addr"`
+	ClientId      string        `json:"client_id,omitempty"`
+	UserId        string        `json:"user_id,omitempty"`
+	Scheme        string        `json:"scheme"`
+	Host          string        `json:"host"`
+	Method        string        `json:"method"`
+	URI           string        `json:"uri"`
+	Proto         string        `json:"proto"`
+	Status        int           `json:"status"`
+	Length        int64         `json:"length"`
+	RequestLength int64         `json:"request_length"`
+	Duration      time.Duration `json:"-"`
+	DurationMs    float64       `json:"duration_ms"`
+	Referer       string        `json:"referer,omitempty"`
+	UserAgent     string        `json:"user_agent,omitempty"`
+	RequestId     string        `json:"request_id,omitempty"`
+	TraceId       string        `json:"trace_id,omitempty"`
+}
+
+// Creates a new LogEntry for the request. If useXForwarded is true the remote
+// address, scheme and host come from the forwarding headers (as far as the
+// DefaultTrustedProxies allow). If the request body was wrapped with a
+// ReadCloser the number of bytes read from it is recorded as well.
+func NewLogEntry(trw *ResponseWriter, r *http.Request, useXForwarded bool, duration time.Duration) *LogEntry {
+	entry := new(LogEntry)
+	entry.Time = time.Now().UTC()
+	entry.RemoteAddr = r.RemoteAddr
+	entry.Scheme = "http"
+	if r.TLS != nil {
+		entry.Scheme = "https"
+	}
+	entry.Host = r.Host
+	// Only believe the forwarding headers as far as DefaultTrustedProxies allows
+	if useXForwarded {
+		entry.RemoteAddr = RealIP(r)
+		entry.Scheme = RealProto(r)
+		entry.Host = RealHost(r)
+	}
+	if remoteHost, _, err := net.SplitHostPort(entry.RemoteAddr); err == nil {
+		entry.RemoteAddr = remoteHost
+	}
+	// Prefer the identity on the context, the headers are the old side channel
+	entry.ClientId = ClientID(r)
+	if entry.ClientId == "" {
+		entry.ClientId = r.Header.Get("X-Client-Id")
+	}
+	entry.UserId = UserID(r)
+	if entry.UserId == "" {
+		entry.UserId = r.Header.Get("X-User-Id")
+	}
+	entry.Method = r.Method
+	entry.URI = r.URL.String()
+	entry.Proto = r.Proto
+	entry.Status = trw.status
+	entry.Length = trw.length
+	if rc, ok := r.Body.(*ReadCloser); ok {
+		entry.RequestLength = rc.Length()
+	}
+	entry.Duration = duration
+	entry.DurationMs = float64(duration) / float64(time.Millisecond)
+	entry.Referer = r.Referer()
+	entry.UserAgent = r.UserAgent()
+	entry.RequestId = RequestID(r)
+	if entry.RequestId == "" {
+		entry.RequestId = trw.Header().Get(RequestIdHeader)
+	}
+	if tc := TraceContextFromContext(r.Context()); tc != nil {
+		entry.TraceId = tc.TraceID
+	}
+	return entry
+}
+
+// Returns the entry as a single line json object.
+func (entry *LogEntry) JSON() string {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
@@ -0,0 +1,220 @@
+package advhttp
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	LogFormatCommon   = `%h %l %u %t "%r" %>s %b`
+	LogFormatCombined = `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i"`
+)
+
+var (
+	logFormatCombined = mustLogFormat(LogFormatCombined)
+)
+
+// A LogFormat is an Apache mod_log_config style format string that has been
+// compiled so that it can be rendered for each request. Like mod_log_config,
+// " and \ are escaped with a \, and control characters as \xhh, in the values
+// so that clients can't break out of quoted fields. The supported directives
+// are:
+//
+//	%%          a literal percent sign
+//	%a          the client ip, resolved through the DefaultTrustedProxies
+//	%A          the local ip the request came in on
+//	%b          the response size in bytes, - for zero
+//	%B          the response size in bytes
+//	%D          the time taken to serve the request in microseconds
+//	%h          the remote host (the peer that connected)
+//	%H          the request protocol
+//	%I          the bytes read from the request body
+//	%l          the remote logname, always -
+//	%L          the request id, see NewRequestIdHandler
+//	%m          the request method
+//	%q          the query string (prefixed with a ?), or empty
+//	%r          the first line of the request
+//	%s, %>s     the response status
+//	%t          the time the request was logged
+//	%T          the time taken to serve the request in seconds
+//	%u          the remote user, from X-User-Id or basic auth
+//	%U          the url path requested
+//	%v          the host the request was made to
+//	%{Name}i    the value of the Name request header
+//	%{Name}o    the value of the Name response header
+//	%{Name}C    the value of the Name cookie
+type LogFormat struct {
+	format string
+	parts  []logFormatPart
+}
+
+type logFormatPart struct {
+	literal   string
+	directive byte
+	arg       string
+}
+
+// Compiles the format string, returning an error for unknown directives.
+func NewLogFormat(format string) (*LogFormat, error) {
+	lf := &LogFormat{format: format}
+	literal := ""
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			literal += format[i : i+1]
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			literal += "%"
+			continue
+		}
+		// Skip the status and original/final request modifiers
+		for i < len(format) && (format[i] == '>' || format[i] == '<' || format[i] == '!' || format[i] == ',' || format[i] >= '0' && format[i] <= '9') {
+			i++
+		}
+		arg := ""
+		if i < len(format) && format[i] == '{' {
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 {
+				return nil, errors.New("Unterminated { in log format: " + format)
+			}
+			arg = format[i+1 : i+end]
+			i += end + 1
+		}
+		if i >= len(format) {
+			return nil, errors.New("Log format ends with an incomplete directive: " + format)
+		}
+		d := format[i]
+		if !strings.ContainsRune("aAbBDhHIlLmqrstTuUv", rune(d)) && !(arg != "" && strings.ContainsRune("ioC", rune(d))) {
+			return nil, errors.New("Unknown log format directive %" + string(d) + " in: " + format)
+		}
+		if literal != "" {
+			lf.parts = append(lf.parts, logFormatPart{literal: literal})
+			literal = ""
+		}
+		lf.parts = append(lf.parts, logFormatPart{directive: d, arg: arg})
+	}
+	if literal != "" {
+		lf.parts = append(lf.parts, logFormatPart{literal: literal})
+	}
+	return lf, nil
+}
+
+func mustLogFormat(format string) *LogFormat {
+	lf, err := NewLogFormat(format)
+	if err != nil {
+		panic(err)
+	}
+	return lf
+}
+
+func (lf *LogFormat) String() string {
+	return lf.format
+}
+
+// Renders the log line for the request once it has been served.
+func (lf *LogFormat) Format(trw *ResponseWriter, r *http.Request, duration time.Duration) string {
+	entry := NewLogEntry(trw, r, false, duration)
+	var sb strings.Builder
+	for _, part := range lf.parts {
+		if part.directive == 0 {
+			sb.WriteString(part.literal)
+			continue
+		}
+		sb.WriteString(escapeLogValue(lf.directive(part, entry, trw, r)))
+	}
+	return sb.String()
+}
+
+func (lf *LogFormat) directive(part logFormatPart, entry *LogEntry, trw *ResponseWriter, r *http.Request) string {
+	switch part.directive {
+	case 'a':
+		return orDash(forwardedNodeIP(RealIP(r)))
+	case 'A':
+		if localAddr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			return forwardedNodeIP(localAddr.String())
+		}
+		return "-"
+	case 'b':
+		if entry.Length == 0 {
+			return "-"
+		}
+		return strconv.FormatInt(entry.Length, 10)
+	case 'B':
+		return strconv.FormatInt(entry.Length, 10)
+	case 'D':
+		return strconv.FormatInt(int64(entry.Duration/time.Microsecond), 10)
+	case 'h':
+		return orDash(entry.RemoteAddr)
+	case 'H':
+		return entry.Proto
+	case 'I':
+		return strconv.FormatInt(entry.RequestLength, 10)
+	case 'l':
+		return "-"
+	case 'L':
+		return orDash(entry.RequestId)
+	case 'm':
+		return entry.Method
+	case 'q':
+		if r.URL.RawQuery != "" {
+			return "?" + r.URL.RawQuery
+		}
+		return ""
+	case 'r':
+		return entry.Method + " " + entry.URI + " " + entry.Proto
+	case 's':
+		return strconv.Itoa(entry.Status)
+	case 't':
+		return entry.Time.Local().Format("[02/Jan/2006:15:04:05 -0700]")
+	case 'T':
+		return strconv.FormatInt(int64(entry.Duration/time.Second), 10)
+	case 'u':
+		if entry.UserId != "" {
+			return entry.UserId
+		}
+		if username, _, ok := r.BasicAuth(); ok && username != "" {
+			return username
+		}
+		return "-"
+	case 'U':
+		return r.URL.Path
+	case 'v':
+		return orDash(entry.Host)
+	case 'i':
+		return orDash(r.Header.Get(part.arg))
+	case 'o':
+		return orDash(trw.Header().Get(part.arg))
+	case 'C':
+		if cookie, err := r.Cookie(part.arg); err == nil {
+			return cookie.Value
+		}
+		return "-"
+	}
+	return ""
+}
+
+// Escapes the value like mod_log_config, so it can't end a quoted field or
+// the line early
+func escapeLogValue(v string) string {
+	var sb strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			sb.WriteString(`\x`)
+			sb.WriteByte("0123456789abcdef"[c>>4])
+			sb.WriteByte("0123456789abcdef"[c&0xf])
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
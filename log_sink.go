@@ -0,0 +1,188 @@
+package advhttp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// Block the request until there is room in the queue
+	LogSinkBlock = iota
+	// Drop the line (and count it) if the queue is full
+	LogSinkDrop
+)
+
+var (
+	LogSinkDefaultQueueSize = 1024
+	LogSinkDefaultBatchSize = 64
+
+	ErrLogSinkClosed = errors.New("Log sink is closed")
+)
+
+// The LogSink is an io.Writer that queues each write and writes it out to the
+// wrapped io.Writer from a background goroutine, so that a slow disk or pipe
+// doesn't hold up the requests being logged. Lines are written out in batches
+// of up to BatchSize. When the queue is full the Policy decides whether the
+// write blocks (LogSinkBlock) or is dropped (LogSinkDrop).
+//
+// It is meant to be given to the logging handlers in place of the writer it
+// wraps:
+//
+//	sink := NewLogSink(os.Stdout, LogSinkDefaultQueueSize, LogSinkDrop)
+//	defer sink.Close()
+//	lh := NewLoggingHandler(h, sink)
+type LogSink struct {
+	Policy    int
+	BatchSize int
+
+	w       io.Writer
+	queue   chan []byte
+	flushes chan chan error
+	done    chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+	err    error
+
+	dropped uint64
+	written uint64
+}
+
+// Creates a new LogSink writing to w with a queue of queueSize lines.
+func NewLogSink(w io.Writer, queueSize int, policy int) *LogSink {
+	sink := new(LogSink)
+	sink.Policy = policy
+	sink.BatchSize = LogSinkDefaultBatchSize
+	sink.w = w
+	sink.queue = make(chan []byte, queueSize)
+	sink.flushes = make(chan chan error)
+	sink.done = make(chan struct{})
+	go sink.run()
+	return sink
+}
+
+// Queues p to be written. A copy of p is queued so the caller may re-use it.
+func (sink *LogSink) Write(p []byte) (int, error) {
+	sink.mu.RLock()
+	defer sink.mu.RUnlock()
+	if sink.closed {
+		return 0, ErrLogSinkClosed
+	}
+	line := append([]byte(nil), p...)
+	if sink.Policy == LogSinkDrop {
+		select {
+		case sink.queue <- line:
+		default:
+			atomic.AddUint64(&sink.dropped, 1)
+		}
+		return len(p), nil
+	}
+	sink.queue <- line
+	return len(p), nil
+}
+
+// Returns the number of writes dropped because the queue was full.
+func (sink *LogSink) Dropped() uint64 {
+	return atomic.LoadUint64(&sink.dropped)
+}
+
+// Returns the number of writes that have been written out.
+func (sink *LogSink) Written() uint64 {
+	return atomic.LoadUint64(&sink.written)
+}
+
+// Blocks until everything queued before the call has been written out, and
+// returns the last error from the wrapped writer, if any.
+func (sink *LogSink) Flush() error {
+	sink.mu.RLock()
+	if sink.closed {
+		sink.mu.RUnlock()
+		<-sink.done
+		return sink.err
+	}
+	reply := make(chan error)
+	sink.flushes <- reply
+	sink.mu.RUnlock()
+	return <-reply
+}
+
+// Writes out everything still queued and stops the sink. Writes after Close
+// return ErrLogSinkClosed.
+func (sink *LogSink) Close() error {
+	sink.mu.Lock()
+	if sink.closed {
+		sink.mu.Unlock()
+		return sink.err
+	}
+	sink.closed = true
+	close(sink.queue)
+	sink.mu.Unlock()
+	<-sink.done
+	return sink.err
+}
+
+func (sink *LogSink) run() {
+	defer close(sink.done)
+	batch := new(bytes.Buffer)
+	for {
+		select {
+		case line, ok := <-sink.queue:
+			if !ok {
+				return
+			}
+			batch.Write(line)
+			n := 1
+			// Grab whatever else is waiting, up to the batch size
+		fill:
+			for n < sink.BatchSize {
+				select {
+				case line, ok = <-sink.queue:
+					if !ok {
+						break fill
+					}
+					batch.Write(line)
+					n++
+				default:
+					break fill
+				}
+			}
+			sink.writeBatch(batch, n)
+		case reply := <-sink.flushes:
+			n := 0
+		drain:
+			for {
+				select {
+				case line, ok := <-sink.queue:
+					if !ok {
+						break drain
+					}
+					batch.Write(line)
+					n++
+				default:
+					break drain
+				}
+			}
+			if n > 0 {
+				sink.writeBatch(batch, n)
+			}
+			if f, ok := sink.w.(interface{ Flush() error }); ok {
+				if err := f.Flush(); err != nil {
+					sink.err = err
+				}
+			}
+			reply <- sink.err
+		}
+	}
+}
+
+func (sink *LogSink) writeBatch(batch *bytes.Buffer, n int) {
+	if _, err := sink.w.Write(batch.Bytes()); err != nil {
+		sink.err = err
+	} else {
+		atomic.AddUint64(&sink.written, uint64(n))
+	}
+	batch.Reset()
+}
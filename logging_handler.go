@@ -0,0 +1,115 @@
+package advhttp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// A LogFunc formats the log line for a request once it has been served.
+type LogFunc func(trw *ResponseWriter, r *http.Request, duration time.Duration) string
+
+// Returns a LogFunc for the `Common Log Format` variation used by LogWithOptions
+func CommonExtendedLogFunc(useXForwarded bool) LogFunc {
+	return func(trw *ResponseWriter, r *http.Request, duration time.Duration) string {
+		return LogWithOptions(trw, r, useXForwarded, duration)
+	}
+}
+
+// Returns a LogFunc that formats a LogEntry as a json object
+func JSONLogFunc(useXForwarded bool) LogFunc {
+	return func(trw *ResponseWriter, r *http.Request, duration time.Duration) string {
+		return NewLogEntry(trw, r, useXForwarded, duration).JSON()
+	}
+}
+
+// The LoggingHandler is the handler behind all of the logging handler
+// constructors. It wraps the response in a ResponseWriter and the request body
+// in a ReadCloser, puts an Identity on the request context, serves the request,
+// and then writes the line returned by Format to Log, if the Filter allows it.
+// Inner handlers record who made the request with SetUserID and SetClientID.
+type LoggingHandler struct {
+	Handler http.Handler
+	Log     io.Writer
+	Format  LogFunc
+	// Decides which requests get logged, nil logs all of them
+	Filter *LogFilter
+	// If set the start of the request and response bodies are captured
+	Debug *DebugLog
+}
+
+func (lh *LoggingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Don't let clients pick who they are logged as
+	r.Header.Del("X-User-Id")
+	r.Header.Del("X-Client-Id")
+	r = WithIdentity(r)
+	trw := NewResponseWriter(w)
+	if r.Body != nil {
+		rc := NewReadCloser(r.Body)
+		if lh.Debug != nil {
+			rc.Capture(lh.Debug.MaxBodyBytes)
+		}
+		r.Body = rc
+	}
+	if lh.Debug != nil {
+		trw.Capture(lh.Debug.MaxBodyBytes)
+	}
+	origURI := r.URL.RequestURI()
+	start := time.Now()
+	lh.Handler.ServeHTTP(trw, r)
+	duration := time.Now().Sub(start)
+	r.URL, _ = url.Parse(origURI)
+	if lh.Filter != nil && !lh.Filter.ShouldLog(trw, r, duration) {
+		return
+	}
+	fmt.Fprintln(lh.Log, lh.Format(trw, r, duration))
+}
+
+// A LogFilter decides which requests are worth logging. Server errors (5xx) and
+// requests slower than SlowThreshold are always logged. Otherwise requests
+// matching any of the skip rules are not logged, and only one in every
+// SampleRate successful (< 400) requests is.
+type LogFilter struct {
+	// Requests with a path starting with any of these prefixes are skipped
+	SkipPaths []string
+	// Requests with any of these methods are skipped
+	SkipMethods []string
+	// Requests with any of these response statuses are skipped
+	SkipStatuses []int
+	// Log one in every SampleRate successful requests, 0 or 1 logs them all
+	SampleRate uint64
+	// Requests taking longer than this are always logged, 0 disables
+	SlowThreshold time.Duration
+
+	count uint64
+}
+
+// Returns whether the served request should be logged.
+func (f *LogFilter) ShouldLog(trw *ResponseWriter, r *http.Request, duration time.Duration) bool {
+	if trw.Status() >= 500 || (f.SlowThreshold > 0 && duration > f.SlowThreshold) {
+		return true
+	}
+	for _, prefix := range f.SkipPaths {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return false
+		}
+	}
+	for _, method := range f.SkipMethods {
+		if strings.EqualFold(r.Method, method) {
+			return false
+		}
+	}
+	for _, status := range f.SkipStatuses {
+		if trw.Status() == status {
+			return false
+		}
+	}
+	if f.SampleRate > 1 && trw.Status() < 400 {
+		return atomic.AddUint64(&f.count, 1)%f.SampleRate == 1
+	}
+	return true
+}
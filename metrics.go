@@ -0,0 +1,202 @@
+package advhttp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	MetricsDefaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	MetricsDefaultSizeBuckets     = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+)
+
+// The Metrics structure records request counts, duration and response size
+// histograms and in flight gauges for the handlers it wraps, labeled by method,
+// status class (2xx, 4xx, etc) and route name. It is also an http.Handler that
+// serves the metrics in the Prometheus text exposition format, so it can be
+// mounted on /metrics.
+//
+//	m := NewMetrics()
+//	http.Handle("/users/", m.Handler("users", usersHandler))
+//	http.Handle("/metrics", m)
+type Metrics struct {
+	// The upper bounds (in seconds) of the request duration histogram buckets
+	DurationBuckets []float64
+	// The upper bounds (in bytes) of the response size histogram buckets
+	SizeBuckets []float64
+
+	mu       sync.Mutex
+	series   map[metricsKey]*metricsSeries
+	inFlight map[string]int64
+}
+
+type metricsKey struct {
+	method string
+	status string
+	route  string
+}
+
+type metricsSeries struct {
+	count     uint64
+	durations *metricsHistogram
+	sizes     *metricsHistogram
+}
+
+type metricsHistogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (mh *metricsHistogram) observe(v float64) {
+	for i, upper := range mh.buckets {
+		if v <= upper {
+			mh.counts[i]++
+		}
+	}
+	mh.sum += v
+	mh.count++
+}
+
+// Returns a new Metrics with the default histogram buckets.
+func NewMetrics() *Metrics {
+	m := new(Metrics)
+	m.DurationBuckets = MetricsDefaultDurationBuckets
+	m.SizeBuckets = MetricsDefaultSizeBuckets
+	m.series = make(map[metricsKey]*metricsSeries)
+	m.inFlight = make(map[string]int64)
+	return m
+}
+
+// Returns a handler that records metrics for h under the given route name.
+func (m *Metrics) Handler(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.addInFlight(route, 1)
+		defer m.addInFlight(route, -1)
+		trw := NewResponseWriter(w)
+		start := time.Now()
+		h.ServeHTTP(trw, r)
+		m.Observe(r.Method, trw.Status(), route, time.Now().Sub(start), trw.Length())
+	})
+}
+
+// Records a single served request.
+func (m *Metrics) Observe(method string, status int, route string, duration time.Duration, size int64) {
+	key := metricsKey{method: metricsMethod(method), status: strconv.Itoa(status/100) + "xx", route: route}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricsSeries{durations: newMetricsHistogram(m.DurationBuckets), sizes: newMetricsHistogram(m.SizeBuckets)}
+		m.series[key] = s
+	}
+	s.count++
+	s.durations.observe(duration.Seconds())
+	s.sizes.observe(float64(size))
+}
+
+func (m *Metrics) addInFlight(route string, delta int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight[route] += delta
+}
+
+// Serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// Writes the metrics in the Prometheus text exposition format to w.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	keys := make([]metricsKey, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+	routes := make([]string, 0, len(m.inFlight))
+	for route := range m.inFlight {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	var sb strings.Builder
+	sb.WriteString("# HELP http_requests_total Total number of HTTP requests served.\n")
+	sb.WriteString("# TYPE http_requests_total counter\n")
+	for _, key := range keys {
+		fmt.Fprintf(&sb, "http_requests_total{%v} %d\n", key.labels(), m.series[key].count)
+	}
+	sb.WriteString("# HELP http_request_duration_seconds Time taken to serve HTTP requests.\n")
+	sb.WriteString("# TYPE http_request_duration_seconds histogram\n")
+	for _, key := range keys {
+		m.series[key].durations.write(&sb, "http_request_duration_seconds", key.labels())
+	}
+	sb.WriteString("# HELP http_response_size_bytes Size of HTTP response bodies.\n")
+	sb.WriteString("# TYPE http_response_size_bytes histogram\n")
+	for _, key := range keys {
+		m.series[key].sizes.write(&sb, "http_response_size_bytes", key.labels())
+	}
+	sb.WriteString("# HELP http_requests_in_flight Number of HTTP requests currently being served.\n")
+	sb.WriteString("# TYPE http_requests_in_flight gauge\n")
+	for _, route := range routes {
+		fmt.Fprintf(&sb, "http_requests_in_flight{route=\"%v\"} %d\n", escapeMetricsLabel(route), m.inFlight[route])
+	}
+	m.mu.Unlock()
+
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+func newMetricsHistogram(buckets []float64) *metricsHistogram {
+	return &metricsHistogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (mh *metricsHistogram) write(sb *strings.Builder, name string, labels string) {
+	for i, upper := range mh.buckets {
+		fmt.Fprintf(sb, "%v_bucket{%v,le=\"%v\"} %d\n", name, labels, strconv.FormatFloat(upper, 'g', -1, 64), mh.counts[i])
+	}
+	fmt.Fprintf(sb, "%v_bucket{%v,le=\"+Inf\"} %d\n", name, labels, mh.count)
+	fmt.Fprintf(sb, "%v_sum{%v} %v\n", name, labels, strconv.FormatFloat(mh.sum, 'g', -1, 64))
+	fmt.Fprintf(sb, "%v_count{%v} %d\n", name, labels, mh.count)
+}
+
+func (key metricsKey) labels() string {
+	return fmt.Sprintf("method=\"%v\",route=\"%v\",status=\"%v\"", key.method, escapeMetricsLabel(key.route), key.status)
+}
+
+// Keeps the method label from growing without bound
+func metricsMethod(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE":
+		return method
+	}
+	return "OTHER"
+}
+
+func escapeMetricsLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+var DefaultMetrics *Metrics
+
+// Returns a handler that records metrics for h under the route name in the
+// DefaultMetrics. Serve the metrics by mounting advhttp.DefaultMetrics.
+func NewMetricsHandler(h http.Handler, route string) http.Handler {
+	return DefaultMetrics.Handler(route, h)
+}
@@ -0,0 +1,185 @@
+package advhttp
+
+import (
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An AcceptSpec is one entry of an Accept, Accept-Language, Accept-Encoding or
+// Accept-Charset header.
+type AcceptSpec struct {
+	// The media range, language range, content coding or charset, lower cased
+	Value string
+	// The media type parameters, other than q, nil if there are none
+	Params map[string]string
+	// The quality value, from 0 (not acceptable) to 1
+	Q float64
+}
+
+// Parses an Accept* header into it's entries, ordered by quality (highest
+// first) and then by the order they were given in.
+func ParseAccept(header string) []AcceptSpec {
+	specs := make([]AcceptSpec, 0)
+	for _, ent := range splitForwarded(header, ',') {
+		parts := splitForwarded(ent, ';')
+		if len(parts) == 0 {
+			continue
+		}
+		spec := AcceptSpec{Value: strings.ToLower(parts[0]), Q: 1}
+		for _, param := range parts[1:] {
+			k, v := param, ""
+			if i := strings.IndexByte(param, '='); i >= 0 {
+				k, v = strings.TrimSpace(param[:i]), unquoteForwardedValue(strings.TrimSpace(param[i+1:]))
+			}
+			k = strings.ToLower(k)
+			if k == "q" {
+				if q, err := strconv.ParseFloat(v, 64); err == nil {
+					spec.Q = q
+				}
+				if spec.Q < 0 {
+					spec.Q = 0
+				} else if spec.Q > 1 {
+					spec.Q = 1
+				}
+				// Anything after the q value is an accept extension
+				break
+			}
+			if spec.Params == nil {
+				spec.Params = make(map[string]string)
+			}
+			spec.Params[k] = v
+		}
+		specs = append(specs, spec)
+	}
+	sort.SliceStable(specs, func(i, j int) bool {
+		return specs[i].Q > specs[j].Q
+	})
+	return specs
+}
+
+// Returns the offered media type the Accept header prefers, or empty if none of
+// them are acceptable. Each offer gets the quality of the most specific media
+// range that matches it (eg text/html;level=1 over text/html over text/* over
+// */*), and ties go to the earliest offer. If there is no Accept header the
+// first offer is returned.
+//
+//	switch NegotiateContentType(r.Header.Get("Accept"), "application/json", "text/html") {
+func NegotiateContentType(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return firstOffer(offers)
+	}
+	return negotiate(ParseAccept(accept), offers, matchMediaType)
+}
+
+// Returns the offered language tag the Accept-Language header prefers, or empty
+// if none of them are acceptable. Language ranges match tags that are equal or
+// start with the range followed by a -, so en matches en-US. If there is no
+// Accept-Language header the first offer is returned.
+func NegotiateLanguage(acceptLanguage string, offers ...string) string {
+	if strings.TrimSpace(acceptLanguage) == "" {
+		return firstOffer(offers)
+	}
+	return negotiate(ParseAccept(acceptLanguage), offers, matchLanguage)
+}
+
+// Returns the offered content coding the Accept-Encoding header prefers, or
+// empty if none of them are acceptable. The identity coding is acceptable,
+// though least preferred, unless the header excludes it (identity;q=0 or *;q=0).
+// If there is no Accept-Encoding header only identity is acceptable.
+func NegotiateEncoding(acceptEncoding string, offers ...string) string {
+	specs := ParseAccept(acceptEncoding)
+	explicit := false
+	for _, spec := range specs {
+		if spec.Value == "identity" || spec.Value == "*" {
+			explicit = true
+		}
+	}
+	if !explicit {
+		specs = append(specs, AcceptSpec{Value: "identity", Q: 0.001})
+	}
+	return negotiate(specs, offers, matchToken)
+}
+
+// Returns the offered charset the Accept-Charset header prefers, or empty if
+// none of them are acceptable. If there is no Accept-Charset header the first
+// offer is returned.
+func NegotiateCharset(acceptCharset string, offers ...string) string {
+	if strings.TrimSpace(acceptCharset) == "" {
+		return firstOffer(offers)
+	}
+	return negotiate(ParseAccept(acceptCharset), offers, matchToken)
+}
+
+// Returns the offer with the highest quality. The match function returns how
+// specific a match the spec is for the offer, or -1 if it doesn't match.
+func negotiate(specs []AcceptSpec, offers []string, match func(AcceptSpec, string) int) string {
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		for _, spec := range specs {
+			if s := match(spec, offer); s > specificity {
+				q, specificity = spec.Q, s
+			}
+		}
+		if specificity >= 0 && q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+func firstOffer(offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	return offers[0]
+}
+
+func matchMediaType(spec AcceptSpec, offer string) int {
+	offerType, offerParams, err := mime.ParseMediaType(offer)
+	if err != nil {
+		offerType = strings.ToLower(offer)
+	}
+	if spec.Value == "*/*" {
+		return 0
+	}
+	slash := strings.IndexByte(offerType, '/')
+	if slash < 0 {
+		return -1
+	}
+	if spec.Value == offerType[:slash+1]+"*" {
+		return 1
+	}
+	if spec.Value != offerType {
+		return -1
+	}
+	for k, v := range spec.Params {
+		if !strings.EqualFold(offerParams[k], v) {
+			return -1
+		}
+	}
+	return 2 + len(spec.Params)
+}
+
+func matchLanguage(spec AcceptSpec, offer string) int {
+	offer = strings.ToLower(offer)
+	if spec.Value == "*" {
+		return 0
+	}
+	if offer == spec.Value || strings.HasPrefix(offer, spec.Value+"-") {
+		return len(spec.Value)
+	}
+	return -1
+}
+
+func matchToken(spec AcceptSpec, offer string) int {
+	if spec.Value == "*" {
+		return 0
+	}
+	if strings.EqualFold(spec.Value, offer) {
+		return 1
+	}
+	return -1
+}
@@ -2,6 +2,7 @@ package advhttp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -152,6 +153,12 @@ func (tt *TokenTracker) GetTokenInformation() (tokenInfo map[string]interface{},
 
 // This method uses the password grant type of oauth2 to get a token from the token endpoint.
 func GetPasswordToken(tokenEndpoint, client_id, client_secret, username, password string, scope []string) (token string, tokenExpires time.Time, refreshToken string, err error) {
+	return GetPasswordTokenContext(context.Background(), tokenEndpoint, client_id, client_secret, username, password, scope)
+}
+
+// Same as GetPasswordToken, but the request is made with the context, passing
+// on any trace context it carries to the token endpoint.
+func GetPasswordTokenContext(ctx context.Context, tokenEndpoint, client_id, client_secret, username, password string, scope []string) (token string, tokenExpires time.Time, refreshToken string, err error) {
 	toSend := &url.Values{}
 	toSend.Add("grant_type", "password")
 	toSend.Add("access_type", "offline")
@@ -159,10 +166,11 @@ func GetPasswordToken(tokenEndpoint, client_id, client_secret, username, passwor
 	toSend.Add("password", password)
 	toSend.Add("scope", strings.Join(scope, " "))
 
-	req, err := http.NewRequest("POST", tokenEndpoint, bytes.NewBuffer([]byte(toSend.Encode())))
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenEndpoint, bytes.NewBuffer([]byte(toSend.Encode())))
 	if err != nil {
 		return
 	}
+	InjectTraceContext(ctx, req.Header)
 	req.SetBasicAuth(client_id, client_secret)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
@@ -199,15 +207,22 @@ func GetPasswordToken(tokenEndpoint, client_id, client_secret, username, passwor
 
 // This method uses the refresh_token grant type of oauth2 to obtain a token from the token endpoint
 func GetRefreshToken(tokenEndpoint, client_id, client_secret, refresh_token string, scope []string) (token string, tokenExpires time.Time, err error) {
+	return GetRefreshTokenContext(context.Background(), tokenEndpoint, client_id, client_secret, refresh_token, scope)
+}
+
+// Same as GetRefreshToken, but the request is made with the context, passing
+// on any trace context it carries to the token endpoint.
+func GetRefreshTokenContext(ctx context.Context, tokenEndpoint, client_id, client_secret, refresh_token string, scope []string) (token string, tokenExpires time.Time, err error) {
 	toSend := &url.Values{}
 	toSend.Add("grant_type", "refresh_token")
 	toSend.Add("refresh_token", refresh_token)
 	toSend.Add("scope", strings.Join(scope, " "))
 
-	req, err := http.NewRequest("POST", tokenEndpoint, bytes.NewBuffer([]byte(toSend.Encode())))
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenEndpoint, bytes.NewBuffer([]byte(toSend.Encode())))
 	if err != nil {
 		return
 	}
+	InjectTraceContext(ctx, req.Header)
 	req.SetBasicAuth(client_id, client_secret)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
@@ -243,14 +258,21 @@ func GetRefreshToken(tokenEndpoint, client_id, client_secret, refresh_token stri
 // This method uses the client_credentials grant_type of oauth2 to obtain a token from the token
 // endpoint.
 func GetClientCredentialsToken(tokenEndpoint, client_id, client_secret string, scope []string) (token string, tokenExpires time.Time, err error) {
+	return GetClientCredentialsTokenContext(context.Background(), tokenEndpoint, client_id, client_secret, scope)
+}
+
+// Same as GetClientCredentialsToken, but the request is made with the context,
+// passing on any trace context it carries to the token endpoint.
+func GetClientCredentialsTokenContext(ctx context.Context, tokenEndpoint, client_id, client_secret string, scope []string) (token string, tokenExpires time.Time, err error) {
 	toSend := &url.Values{}
 	toSend.Add("grant_type", "client_credentials")
 	toSend.Add("scope", strings.Join(scope, " "))
 
-	req, err := http.NewRequest("POST", tokenEndpoint, bytes.NewBuffer([]byte(toSend.Encode())))
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenEndpoint, bytes.NewBuffer([]byte(toSend.Encode())))
 	if err != nil {
 		return
 	}
+	InjectTraceContext(ctx, req.Header)
 	req.SetBasicAuth(client_id, client_secret)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
@@ -286,10 +308,17 @@ func GetClientCredentialsToken(tokenEndpoint, client_id, client_secret string, s
 // This method calls the token information endpoint and returns the json response as a map of
 // string to interface{} values.
 func GetTokenInformation(tokenInfoEndpoint, token string) (tokenInfo map[string]interface{}, err error) {
-	req, err := http.NewRequest("GET", tokenInfoEndpoint, nil)
+	return GetTokenInformationContext(context.Background(), tokenInfoEndpoint, token)
+}
+
+// Same as GetTokenInformation, but the request is made with the context,
+// passing on any trace context it carries to the token info endpoint.
+func GetTokenInformationContext(ctx context.Context, tokenInfoEndpoint, token string) (tokenInfo map[string]interface{}, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", tokenInfoEndpoint, nil)
 	if err != nil {
 		return
 	}
+	InjectTraceContext(ctx, req.Header)
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Authorization", "Bearer "+token)
 
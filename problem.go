@@ -0,0 +1,139 @@
+package advhttp
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	ProblemJSONContentType = "application/problem+json"
+)
+
+var (
+	// The headers removed before a problem is written
+	problemClearHeaders = []string{"Content-Length", "Content-Encoding", "Content-Range", "ETag", "Last-Modified", "Expires", "Cache-Control"}
+
+	// The template used to render problems as HTML, it is executed with the
+	// *Problem
+	ProblemHTMLTemplate = template.Must(template.New("problem").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Status}} {{.Title}}</title></head>
+<body>
+<h1>{{.Status}} {{.Title}}</h1>
+{{if .Detail}}<p>{{.Detail}}</p>
+{{end}}{{if .RequestId}}<p>Request id: {{.RequestId}}</p>
+{{end}}</body>
+</html>
+`))
+)
+
+// A Problem is an RFC 7807 problem details object, describing an error
+// response in a way that can be rendered as application/problem+json, HTML or
+// plain text depending on what the client accepts.
+type Problem struct {
+	// A URI identifying the type of problem, empty for about:blank
+	Type string `json:"type,omitempty"`
+	// A short summary of the problem, the status text by default
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// A human readable explanation of this occurrence of the problem
+	Detail string `json:"detail,omitempty"`
+	// A URI identifying this occurrence of the problem
+	Instance string `json:"instance,omitempty"`
+	// The id of the request, see NewRequestIdHandler
+	RequestId string `json:"request_id,omitempty"`
+}
+
+// Returns a new Problem for the status, titled with the status text.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Title + ": " + p.Detail
+	}
+	return p.Title
+}
+
+// Returns the problem as an application/problem+json document
+func (p *Problem) JSON() string {
+	b, _ := json.Marshal(p)
+	return string(b)
+}
+
+// Returns the problem as an HTML page, rendered with ProblemHTMLTemplate
+func (p *Problem) HTML() string {
+	var sb strings.Builder
+	ProblemHTMLTemplate.Execute(&sb, p)
+	return sb.String()
+}
+
+// Returns the problem as plain text
+func (p *Problem) Text() string {
+	text := strconv.Itoa(p.Status) + " " + p.Title + "\n"
+	if p.Detail != "" {
+		text += p.Detail + "\n"
+	}
+	if p.RequestId != "" {
+		text += "Request id: " + p.RequestId + "\n"
+	}
+	return text
+}
+
+// Writes the problem as the response, in whichever of application/problem+json,
+// text/html or text/plain the request's Accept header prefers. Requests that
+// don't say get json. The problem is given the request id if it doesn't have
+// one. Headers describing the body, like Content-Encoding and ETag, are removed.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.RequestId == "" {
+		p.RequestId = RequestID(r)
+	}
+	var contentType, body string
+	switch negotiateProblemType(r.Header.Get("Accept")) {
+	case "text/html":
+		contentType, body = "text/html; charset=utf-8", p.HTML()
+	case "text/plain":
+		contentType, body = "text/plain; charset=utf-8", p.Text()
+	default:
+		contentType, body = ProblemJSONContentType, p.JSON()
+	}
+	// Headers describing some other representation don't apply to the problem
+	for _, h := range problemClearHeaders {
+		w.Header().Del(h)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	w.Write([]byte(body))
+}
+
+// Writes an error response for the status, with an optional detail message, see
+// WriteProblem. The detail is shown to the client so it shouldn't contain
+// anything internal.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	WriteProblem(w, r, NewProblem(status, detail))
+}
+
+// Writes a 401 Unauthorized error response with the WWW-Authenticate challenge
+// (eg `Bearer realm="api"`), for use by authentication middleware.
+func WriteUnauthorized(w http.ResponseWriter, r *http.Request, challenge string, detail string) {
+	if challenge != "" {
+		w.Header().Set("WWW-Authenticate", challenge)
+	}
+	WriteError(w, r, http.StatusUnauthorized, detail)
+}
+
+// Returns which of the problem content types the accept header prefers
+func negotiateProblemType(accept string) string {
+	switch NegotiateContentType(accept, ProblemJSONContentType, "application/json", "text/html", "text/plain") {
+	case "text/html":
+		return "text/html"
+	case "text/plain":
+		return "text/plain"
+	}
+	return ProblemJSONContentType
+}
@@ -0,0 +1,110 @@
+package advhttp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+)
+
+var (
+	PanicRecoveryDefaultStackSize = 64 << 10
+)
+
+// A PanicReport describes a panic recovered while serving a request.
+type PanicReport struct {
+	Request *http.Request
+	// The value the handler panicked with
+	Value interface{}
+	// The value as an error
+	Err error
+	// The stack of the goroutine that panicked
+	Stack []byte
+	// The id of the request, see NewRequestIdHandler
+	RequestId string
+	// Whether the response had already been sent, in which case no error
+	// response could be written
+	Committed bool
+}
+
+// Logs the panic, with it's stack, to the standard logger.
+func LogPanicReporter(report *PanicReport) {
+	log.Printf("advhttp: panic serving %v %v (request id: %v): %v\n%s", report.Request.Method, report.Request.URL.RequestURI(), orDash(report.RequestId), report.Err, report.Stack)
+}
+
+// The PanicRecovery handler recovers panics from the handler it wraps. Each
+// panic is passed, along with it's stack, to the Reporter. The client gets a
+// generic 500 Internal Server Error response (see WriteError) that doesn't leak
+// the panic message, unless the response had already been committed, in which case
+// nothing more is written. Any headers the panicking handler set are dropped,
+// only those set before it was called are sent with the error.
+//
+// Panics with http.ErrAbortHandler are deliberate aborts and are re-panicked
+// without being reported.
+type PanicRecovery struct {
+	Handler http.Handler
+	// Called for every recovered panic, nil for LogPanicReporter
+	Reporter func(*PanicReport)
+	// The most bytes of stack captured
+	StackSize int
+}
+
+// Returns a PanicRecovery for h that reports to LogPanicReporter.
+func NewPanicRecovery(h http.Handler) *PanicRecovery {
+	pr := new(PanicRecovery)
+	pr.Handler = h
+	pr.Reporter = LogPanicReporter
+	pr.StackSize = PanicRecoveryDefaultStackSize
+	return pr
+}
+
+func (pr *PanicRecovery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	trw, ok := w.(*ResponseWriter)
+	if !ok {
+		trw = NewResponseWriter(w)
+	}
+	// The headers set by the middleware outside, restored if the handler panics
+	before := trw.Header().Clone()
+	defer func() {
+		rec := recover()
+		if rec == nil {
+			return
+		}
+		if rec == http.ErrAbortHandler {
+			panic(rec)
+		}
+		report := &PanicReport{Request: r, Value: rec, RequestId: RequestID(r), Committed: trw.Committed()}
+		switch t := rec.(type) {
+		case string:
+			report.Err = errors.New(t)
+		case error:
+			report.Err = t
+		default:
+			report.Err = fmt.Errorf("%v", t)
+		}
+		size := pr.StackSize
+		if size <= 0 {
+			size = PanicRecoveryDefaultStackSize
+		}
+		report.Stack = make([]byte, size)
+		report.Stack = report.Stack[:runtime.Stack(report.Stack, false)]
+		if pr.Reporter != nil {
+			pr.Reporter(report)
+		} else {
+			LogPanicReporter(report)
+		}
+		if report.Committed {
+			return
+		}
+		headers := trw.Header()
+		for k := range headers {
+			delete(headers, k)
+		}
+		for k, v := range before {
+			headers[k] = v
+		}
+		WriteError(trw, r, http.StatusInternalServerError, "")
+	}()
+	pr.Handler.ServeHTTP(trw, r)
+}
@@ -7,11 +7,20 @@ import (
 type ReadCloser struct {
 	rc     io.ReadCloser
 	length int64
+
+	captured     []byte
+	captureLimit int
 }
 
 func (rc *ReadCloser) Read(p []byte) (int, error) {
 	n, err := rc.rc.Read(p)
 	rc.length += int64(n)
+	if room := rc.captureLimit - len(rc.captured); room > 0 && n > 0 {
+		if room > n {
+			room = n
+		}
+		rc.captured = append(rc.captured, p[:room]...)
+	}
 	return n, err
 }
 
@@ -23,6 +32,16 @@ func (rc *ReadCloser) Length() int64 {
 	return rc.length
 }
 
+// Keeps a copy of the first n bytes read, available from Captured()
+func (rc *ReadCloser) Capture(n int) {
+	rc.captureLimit = n
+}
+
+// Returns the bytes captured so far, see Capture()
+func (rc *ReadCloser) Captured() []byte {
+	return rc.captured
+}
+
 func NewReadCloser(rc io.ReadCloser) *ReadCloser {
 	arc := new(ReadCloser)
 	arc.rc = rc
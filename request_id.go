@@ -0,0 +1,71 @@
+package advhttp
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	RequestIdHeader = "X-Request-Id"
+)
+
+var (
+	// The longest incoming request id that will be accepted
+	RequestIdMaxLength = 128
+)
+
+type requestIdContextKey struct{}
+
+// Returns a copy of the context carrying the request id.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdContextKey{}, id)
+}
+
+// Returns the request id on the context, or empty if there isn't one.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdContextKey{}).(string)
+	return id
+}
+
+// Returns the id of the request, or empty if it didn't come through a request
+// id handler.
+func RequestID(r *http.Request) string {
+	return RequestIDFromContext(r.Context())
+}
+
+// Returns whether an incoming request id is acceptable. Ids must be non empty,
+// no longer than RequestIdMaxLength, and only contain letters, digits and
+// - _ . : characters.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > RequestIdMaxLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a new random request id.
+func NewRequestID() string {
+	return randomHex(16)
+}
+
+// Returns a handler that makes sure every request has an id. A valid incoming
+// X-Request-Id header is kept, otherwise a new id is generated. The id is put
+// on the request context, the request header (so proxies pass it upstream) and
+// the response header. The logging handlers and the panic recovery handler
+// include it in their output.
+func NewRequestIdHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIdHeader)
+		if !ValidRequestID(id) {
+			id = NewRequestID()
+		}
+		r.Header.Set(RequestIdHeader, id)
+		w.Header().Set(RequestIdHeader, id)
+		h.ServeHTTP(w, r.WithContext(ContextWithRequestID(r.Context(), id)))
+	})
+}
@@ -3,6 +3,7 @@ package advhttp
 import (
 	"bufio"
 	"errors"
+	"io"
 	"net"
 	"net/http"
 	"time"
@@ -15,8 +16,12 @@ import (
 type ResponseWriter struct {
 	w http.ResponseWriter
 
-	length int64
-	status int
+	length    int64
+	status    int
+	committed bool
+
+	captured     []byte
+	captureLimit int
 }
 
 // Creates a new ResponseWriter wrapping the given http.ResponseWriter
@@ -29,16 +34,38 @@ func (trw *ResponseWriter) Header() http.Header {
 }
 
 func (trw *ResponseWriter) WriteHeader(status int) {
-	trw.status = status
+	// Informational responses don't commit the headers, and superfluous calls
+	// don't change the status that was sent
+	if !trw.committed && status >= 200 {
+		trw.status = status
+		trw.committed = true
+	}
 	trw.w.WriteHeader(status)
 }
 
 func (trw *ResponseWriter) Write(bytes []byte) (int, error) {
+	trw.committed = true
 	n, err := trw.w.Write(bytes)
 	trw.length += int64(n)
+	if room := trw.captureLimit - len(trw.captured); room > 0 && n > 0 {
+		if room > n {
+			room = n
+		}
+		trw.captured = append(trw.captured, bytes[:room]...)
+	}
 	return n, err
 }
 
+// Keeps a copy of the first n bytes written, available from Captured()
+func (trw *ResponseWriter) Capture(n int) {
+	trw.captureLimit = n
+}
+
+// Returns the bytes captured so far, see Capture()
+func (trw *ResponseWriter) Captured() []byte {
+	return trw.captured
+}
+
 func (trw *ResponseWriter) Length() int64 {
 	return trw.length
 }
@@ -47,17 +74,53 @@ func (trw *ResponseWriter) Status() int {
 	return trw.status
 }
 
+// Returns whether the status and headers have been sent (or the connection
+// hijacked), after which the response can no longer be changed.
+func (trw *ResponseWriter) Committed() bool {
+	return trw.committed
+}
+
 func (trw *ResponseWriter) GetFlusher() (flusher http.Flusher, ok bool) {
 	flusher, ok = trw.w.(http.Flusher)
 	return
 }
 
 func (trw *ResponseWriter) Flush() {
+	trw.committed = true
 	if flusher, ok := trw.w.(http.Flusher); ok {
 		flusher.Flush()
 	}
 }
 
+// Copies src to the underlying writer using it's io.ReaderFrom, so that
+// sendfile is still used for files, unless the body is being captured.
+func (trw *ResponseWriter) ReadFrom(src io.Reader) (n int64, err error) {
+	trw.committed = true
+	if rf, ok := trw.w.(io.ReaderFrom); ok && len(trw.captured) >= trw.captureLimit {
+		n, err = rf.ReadFrom(src)
+		trw.length += n
+		return
+	}
+	return io.Copy(writerOnly{trw}, src)
+}
+
+func (trw *ResponseWriter) GetPusher() (pusher http.Pusher, ok bool) {
+	pusher, ok = trw.w.(http.Pusher)
+	return
+}
+
+func (trw *ResponseWriter) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := trw.w.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Returns the wrapped http.ResponseWriter, for use by http.ResponseController
+func (trw *ResponseWriter) Unwrap() http.ResponseWriter {
+	return trw.w
+}
+
 func (trw *ResponseWriter) GetHijacker() (hijacker http.Hijacker, ok bool) {
 	hijacker, ok = trw.w.(http.Hijacker)
 	return
@@ -65,6 +128,7 @@ func (trw *ResponseWriter) GetHijacker() (hijacker http.Hijacker, ok bool) {
 
 func (trw *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if hijacker, ok := trw.w.(http.Hijacker); ok {
+		trw.committed = true
 		return hijacker.Hijack()
 	}
 	return nil, nil, errors.New("Couldn't cast responsewriter to hijacker")
@@ -82,6 +146,15 @@ func (trw *ResponseWriter) CloseNotify() <-chan bool {
 	return nil
 }
 
+// Hides the ReadFrom method of the writer so io.Copy doesn't call back into it
+type writerOnly struct {
+	io.Writer
+}
+
+func (trw *ResponseWriter) LogApache(r *http.Request) string {
+	return LogApache(trw, r)
+}
+
 func (trw *ResponseWriter) LogCommonExtended(r *http.Request) string {
 	return LogCommonExtended(trw, r)
 }
@@ -1,14 +1,244 @@
 package advhttp
 
 import (
+	"context"
+	"log"
 	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"strings"
+	"sync/atomic"
 )
 
+var (
+	GatewayDefaultVia = "1.1 advhttp"
+)
+
+// The GatewayReverseProxy is a reverse proxy meant to be mounted on a path of
+// your own server (eg /google/) that forwards requests on to the target server.
+// The mount prefix can be stripped from the path before it is sent upstream,
+// and any Location headers that come back are re-written to include the prefix
+// again so that clients (mostly browsers) keep talking through the gateway.
+type GatewayReverseProxy struct {
+	// The upstream server that requests will be sent to
+	Target *url.URL
+	// If set, requests are spread across the pool instead of going to Target
+	Pool *UpstreamPool
+	// Whether or not to remove the Prefix from the path before going upstream
+	StripPrefix bool
+	// The path this proxy is mounted on
+	Prefix string
+	// The value added to the Via header on requests and responses
+	Via string
+	// Whether to re-write urls in html, css and json bodies, see BodyRewriter
+	RewriteBody bool
+	// The underlying reverse proxy, exposed so that the transport, error
+	// handler, etc can be customized.
+	Proxy *httputil.ReverseProxy
+}
+
+// Returns a new GatewayReverseProxy that will proxy requests to the target. If
+// stripPrefix is true the prefix will be removed from the request path before
+// being appended to the target path. The returned proxy is an http.Handler and
+// can be mounted directly on a ServeMux at the prefix.
+func NewGatewayReverseProxy(target *url.URL, stripPrefix bool, prefix string) *GatewayReverseProxy {
+	grp := new(GatewayReverseProxy)
+	grp.Target = target
+	grp.StripPrefix = stripPrefix
+	grp.Prefix = prefix
+	grp.Via = GatewayDefaultVia
+	grp.Proxy = &httputil.ReverseProxy{Rewrite: grp.rewrite, ModifyResponse: grp.modifyResponse, ErrorHandler: grp.errorHandler}
+	return grp
+}
+
+// Returns a new GatewayReverseProxy that spreads requests across the upstreams
+// in the pool, otherwise it behaves the same as NewGatewayReverseProxy.
+func NewGatewayPoolReverseProxy(pool *UpstreamPool, stripPrefix bool, prefix string) *GatewayReverseProxy {
+	grp := NewGatewayReverseProxy(nil, stripPrefix, prefix)
+	grp.Pool = pool
+	return grp
+}
+
+type upstreamContextKey struct{}
+
+func (grp *GatewayReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	locationPrefix := ""
+	if grp.StripPrefix {
+		locationPrefix = grp.Prefix
+	}
+	if grp.Pool != nil {
+		u, err := grp.Pool.Next(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		atomic.AddInt64(&u.active, 1)
+		defer atomic.AddInt64(&u.active, -1)
+		r = r.WithContext(context.WithValue(r.Context(), upstreamContextKey{}, u))
+	}
+	if grp.RewriteBody {
+		br := NewBodyRewriter(w, locationPrefix, grp.Via, grp.origins()...)
+		// The reverse proxy panics with http.ErrAbortHandler if the upstream or
+		// client goes away part way through the body
+		aborted := true
+		defer func() {
+			if aborted {
+				br.abort()
+			} else {
+				br.Close()
+			}
+		}()
+		grp.Proxy.ServeHTTP(br, r)
+		aborted = false
+		return
+	}
+	grp.Proxy.ServeHTTP(NewRPResponseWriter(w, locationPrefix, grp.Via), r)
+}
+
+// Returns the scheme and host of every upstream the proxy sends requests to
+func (grp *GatewayReverseProxy) origins() []string {
+	targets := []*url.URL{grp.Target}
+	if grp.Pool != nil {
+		targets = targets[:0]
+		for _, u := range grp.Pool.Upstreams() {
+			targets = append(targets, u.URL)
+		}
+	}
+	origins := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if target != nil {
+			origins = append(origins, target.Scheme+"://"+target.Host)
+		}
+	}
+	return origins
+}
+
+func (grp *GatewayReverseProxy) rewrite(pr *httputil.ProxyRequest) {
+	// The reverse proxy strips the forwarding headers before calling rewrite,
+	// bring them back so that AddOutboundHeaders can append to them.
+	for _, h := range []string{ForwardedHeader, "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"} {
+		if prior, ok := pr.In.Header[h]; ok {
+			pr.Out.Header[h] = prior
+		}
+	}
+
+	target := grp.Target
+	if u, ok := pr.In.Context().Value(upstreamContextKey{}).(*Upstream); ok {
+		target = u.URL
+	}
+
+	p, rp := pr.Out.URL.Path, pr.Out.URL.RawPath
+	if grp.StripPrefix {
+		p = stripPathPrefix(p, grp.Prefix)
+		if rp != "" {
+			rp = stripPathPrefix(rp, grp.Prefix)
+		}
+	}
+	pr.Out.URL.Scheme = target.Scheme
+	pr.Out.URL.Host = target.Host
+	pr.Out.URL.Path = joinURLPath(target.Path, p)
+	pr.Out.URL.RawPath = ""
+	if rp != "" {
+		pr.Out.URL.RawPath = joinURLPath(target.EscapedPath(), rp)
+	}
+	if target.RawQuery == "" || pr.Out.URL.RawQuery == "" {
+		pr.Out.URL.RawQuery = target.RawQuery + pr.Out.URL.RawQuery
+	} else {
+		pr.Out.URL.RawQuery = target.RawQuery + "&" + pr.Out.URL.RawQuery
+	}
+
+	// The body rewriter can only decode gzip, so don't ask for anything else
+	if grp.RewriteBody {
+		if NegotiateEncoding(pr.In.Header.Get("Accept-Encoding"), "gzip", "identity") == "gzip" {
+			pr.Out.Header.Set("Accept-Encoding", "gzip")
+		} else {
+			pr.Out.Header.Del("Accept-Encoding")
+		}
+	}
+
+	AddOutboundHeaders(pr.Out, target.Host, grp.Via)
+}
+
+// Reports the upstream response to the pool so that failing upstreams can be
+// passively ejected.
+func (grp *GatewayReverseProxy) modifyResponse(resp *http.Response) error {
+	if u, ok := resp.Request.Context().Value(upstreamContextKey{}).(*Upstream); ok && grp.Pool != nil {
+		grp.Pool.ReportResult(u, resp.StatusCode < 500)
+	}
+	return nil
+}
+
+// Logs the error, to the Proxy's ErrorLog like the default reverse proxy error
+// handler, reports the failure to the pool and answers with a 502.
+func (grp *GatewayReverseProxy) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if grp.Proxy.ErrorLog != nil {
+		grp.Proxy.ErrorLog.Printf("http: proxy error: %v", err)
+	} else {
+		log.Printf("http: proxy error: %v", err)
+	}
+	//A client that went away isn't the upstream's fault
+	if u, ok := r.Context().Value(upstreamContextKey{}).(*Upstream); ok && grp.Pool != nil && r.Context().Err() == nil {
+		grp.Pool.ReportResult(u, false)
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// Returns whether the path is the prefix or below it, so /api matches /api and
+// /api/users but not /apiv2
+func hasPathPrefix(p, prefix string) bool {
+	if !strings.HasPrefix(p, prefix) {
+		return false
+	}
+	return strings.HasSuffix(prefix, "/") || len(p) == len(prefix) || p[len(prefix)] == '/'
+}
+
+func stripPathPrefix(p, prefix string) string {
+	if trimmed := strings.TrimSuffix(prefix, "/"); hasPathPrefix(p, trimmed) {
+		p = p[len(trimmed):]
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
+}
+
+func joinURLPath(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// Adds the headers a proxy should add to a request on it's way to the given
+// upstream host. The X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto
+// headers are set, an element is appended to the standard Forwarded header and
+// via is appended to the Via header. X-Forwarded-Host and X-Forwarded-Proto
+// are only kept if they came from one of the DefaultTrustedProxies. If the
+// request is being traced the trace context is passed on.
 func AddOutboundHeaders(r *http.Request, host string, via string) {
+	if !DefaultTrustedProxies.Trusted(r.RemoteAddr) {
+		r.Header.Del("X-Forwarded-Host")
+		r.Header.Del("X-Forwarded-Proto")
+	}
+	originalHost := r.Host
 	r.Header.Set("Host", host)
 	r.Host = host
+
+	fe := ForwardedElement{For: ForwardedNode(r.RemoteAddr), Host: originalHost, Proto: "http"}
+	if r.TLS != nil {
+		fe.Proto = "https"
+	}
+	if localAddr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		fe.By = ForwardedNode(localAddr.String())
+	}
+	AppendForwarded(r, fe)
+
 	if clientIP, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
 		// If we aren't the first proxy retain prior
 		// X-Forwarded-For information as a comma+space
@@ -20,7 +250,7 @@ func AddOutboundHeaders(r *http.Request, host string, via string) {
 	}
 
 	if r.Header.Get("X-Forwarded-Host") == "" {
-		r.Header.Set("X-Forwarded-Host", r.Host)
+		r.Header.Set("X-Forwarded-Host", originalHost)
 	}
 
 	if r.Header.Get("X-Forwarded-Proto") == "" {
@@ -31,6 +261,9 @@ func AddOutboundHeaders(r *http.Request, host string, via string) {
 		r.Header.Set("X-Forwarded-Proto", proto)
 	}
 
+	// Pass the trace on to the upstream
+	InjectTraceContext(r.Context(), r.Header)
+
 	// If we aren't the first proxy retain prior
 	// Via information as a comma+space
 	// separated list and fold multiple headers into one.
@@ -0,0 +1,238 @@
+package advhttp
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	rotatingFileTimeFormat = "2006-01-02T15-04-05.000"
+)
+
+// The RotatingFile is an io.Writer for access logs that rotates the file it
+// writes to once it grows past MaxSize bytes and/or every Interval. Rotated
+// segments are renamed with a timestamp suffix (eg access.log.2006-01-02T15-04-05.000),
+// optionally gzipped, and only the newest MaxBackups of them are kept. It can
+// also re-open the file on SIGHUP for use with external rotation tools.
+//
+//	rf, err := NewRotatingFile("/var/log/app/access.log", 100<<20, 24*time.Hour, 7)
+//	rf.Compress = true
+//	rf.ReopenOnSignal()
+//	lh := NewLoggingHandler(h, rf)
+type RotatingFile struct {
+	// The path of the log file
+	Filename string
+	// The size in bytes the file can grow to before it is rotated, zero for no limit
+	MaxSize int64
+	// How often the file is rotated, zero to only rotate by size
+	Interval time.Duration
+	// The number of rotated segments to keep, zero keeps them all
+	MaxBackups int
+	// Whether rotated segments are gzipped
+	Compress bool
+
+	mu           sync.Mutex
+	file         *os.File
+	size         int64
+	nextRotation time.Time
+	signals      chan os.Signal
+
+	cleanup sync.Mutex
+}
+
+// Opens (or creates) the file for appending and returns a RotatingFile for it.
+func NewRotatingFile(filename string, maxSize int64, interval time.Duration, maxBackups int) (*RotatingFile, error) {
+	rf := new(RotatingFile)
+	rf.Filename = filename
+	rf.MaxSize = maxSize
+	rf.Interval = interval
+	rf.MaxBackups = maxBackups
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	if (rf.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.MaxSize) ||
+		(rf.Interval > 0 && !time.Now().Before(rf.nextRotation)) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Rotates the file now, regardless of it's size or age.
+func (rf *RotatingFile) Rotate() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.rotate()
+}
+
+// Closes and re-opens the file, without rotating it. This is what should happen
+// after an external tool has moved the file out of the way.
+func (rf *RotatingFile) Reopen() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file != nil {
+		rf.file.Close()
+		rf.file = nil
+	}
+	return rf.open()
+}
+
+// Re-opens the file whenever one of the given signals is received, SIGHUP if
+// none are given.
+func (rf *RotatingFile) ReopenOnSignal(sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.signals != nil {
+		signal.Stop(rf.signals)
+		close(rf.signals)
+	}
+	rf.signals = make(chan os.Signal, 1)
+	signal.Notify(rf.signals, sigs...)
+	go func(signals chan os.Signal) {
+		for range signals {
+			rf.Reopen()
+		}
+	}(rf.signals)
+}
+
+// Commits the file's contents to disk.
+func (rf *RotatingFile) Flush() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	return rf.file.Sync()
+}
+
+// Closes the file and stops listening for signals.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.signals != nil {
+		signal.Stop(rf.signals)
+		close(rf.signals)
+		rf.signals = nil
+	}
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.Filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	rf.size = info.Size()
+	if rf.Interval > 0 {
+		rf.nextRotation = time.Now().Truncate(rf.Interval).Add(rf.Interval)
+	}
+	return nil
+}
+
+func (rf *RotatingFile) rotate() error {
+	if rf.file != nil {
+		if err := rf.file.Close(); err != nil {
+			return err
+		}
+		rf.file = nil
+	}
+	backup := rf.Filename + "." + time.Now().Format(rotatingFileTimeFormat)
+	if err := os.Rename(rf.Filename, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+	go rf.compressAndPrune(backup)
+	return nil
+}
+
+// Gzips the newly rotated segment (if enabled) and removes the oldest segments
+// past MaxBackups. It runs in the background so writes aren't held up.
+func (rf *RotatingFile) compressAndPrune(backup string) {
+	rf.cleanup.Lock()
+	defer rf.cleanup.Unlock()
+	if rf.Compress {
+		if err := gzipFile(backup); err == nil {
+			os.Remove(backup)
+		}
+	}
+	if rf.MaxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(rf.Filename + ".*")
+	if err != nil {
+		return
+	}
+	backups := make([]string, 0, len(matches))
+	for _, match := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(match, rf.Filename+"."), ".gz")
+		if _, err := time.Parse(rotatingFileTimeFormat, suffix); err == nil {
+			backups = append(backups, match)
+		}
+	}
+	sort.Strings(backups)
+	for len(backups) > rf.MaxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+func gzipFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gw := gzip.NewWriter(out)
+	if _, err = io.Copy(gw, in); err == nil {
+		err = gw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+	}
+	return err
+}
@@ -0,0 +1,197 @@
+package advhttp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	ServerDefaultShutdownTimeout = 30 * time.Second
+)
+
+// The Server runs an http.Server until it receives SIGINT or SIGTERM, and then
+// shuts it down gracefully: it becomes not ready, waits DrainDelay so load
+// balancers checking readiness stop sending it requests, stops accepting
+// connections, waits up to ShutdownTimeout for in-flight requests to finish
+// (closing any still open after that), and finally flushes and closes the
+// Closers so buffered log lines aren't lost.
+//
+//	sink := NewLogSink(os.Stdout, LogSinkDefaultQueueSize, LogSinkDrop)
+//	s := NewServer(":http", DefaultStack(sink).Then(mux))
+//	s.Closers = append(s.Closers, sink)
+//	mux.Handle("/ready", s.ReadyHandler())
+//	err := s.ListenAndServe()
+type Server struct {
+	// The server being run, set up timeouts, TLS, etc here
+	Server *http.Server
+	// How long in-flight requests get to finish once shutdown starts
+	ShutdownTimeout time.Duration
+	// How long to keep serving requests after becoming not ready
+	DrainDelay time.Duration
+	// The signals that start a shutdown, SIGINT and SIGTERM if empty
+	Signals []os.Signal
+	// Flushed (if they have a Flush() error method) and closed, in order, once
+	// the requests have drained. eg a LogSink and then the RotatingFile it
+	// writes to.
+	Closers []io.Closer
+
+	ready    int32
+	once     sync.Once
+	shutdown chan struct{}
+}
+
+// Returns a Server for the handler listening on addr.
+func NewServer(addr string, h http.Handler) *Server {
+	s := new(Server)
+	s.Server = &http.Server{Addr: addr, Handler: h}
+	s.ShutdownTimeout = ServerDefaultShutdownTimeout
+	s.shutdown = make(chan struct{})
+	return s
+}
+
+// Returns whether the server is serving and not shutting down.
+func (s *Server) Ready() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+// Sets whether the server reports itself as ready, eg to take it out of a load
+// balancer for maintenance while it keeps serving.
+func (s *Server) SetReady(ready bool) {
+	if ready {
+		atomic.StoreInt32(&s.ready, 1)
+	} else {
+		atomic.StoreInt32(&s.ready, 0)
+	}
+}
+
+// Returns a handler for readiness checks, it responds 200 OK while the server
+// is ready and 503 Service Unavailable otherwise.
+func (s *Server) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.Ready() {
+			WriteError(w, r, http.StatusServiceUnavailable, "Not ready")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte("OK\n"))
+	})
+}
+
+// Starts a graceful shutdown, as if a signal had been received.
+func (s *Server) Shutdown() {
+	s.once.Do(func() {
+		close(s.shutdown)
+	})
+}
+
+// Listens on the server's address and serves until shut down. It returns nil
+// after a graceful shutdown.
+func (s *Server) ListenAndServe() error {
+	addr := s.Server.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.serve(func() error {
+		return s.Server.Serve(l)
+	})
+}
+
+// Listens on the server's address and serves TLS until shut down. It returns
+// nil after a graceful shutdown.
+func (s *Server) ListenAndServeTLS(certFile string, keyFile string) error {
+	addr := s.Server.Addr
+	if addr == "" {
+		addr = ":https"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.serve(func() error {
+		return s.Server.ServeTLS(l, certFile, keyFile)
+	})
+}
+
+// Serves the connections from l until shut down. It returns nil after a
+// graceful shutdown.
+func (s *Server) Serve(l net.Listener) error {
+	return s.serve(func() error {
+		return s.Server.Serve(l)
+	})
+}
+
+func (s *Server) serve(serve func() error) error {
+	sigs := s.Signals
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, sigs...)
+	defer signal.Stop(signals)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- serve()
+	}()
+	s.SetReady(true)
+
+	select {
+	case err := <-errs:
+		// The server failed on it's own
+		s.SetReady(false)
+		s.close()
+		return err
+	case <-signals:
+	case <-s.shutdown:
+	}
+
+	s.SetReady(false)
+	if s.DrainDelay > 0 {
+		time.Sleep(s.DrainDelay)
+	}
+	timeout := s.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = ServerDefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := s.Server.Shutdown(ctx)
+	if err != nil {
+		// Out of time, drop whatever is still going
+		s.Server.Close()
+	}
+	<-errs
+	if cerr := s.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Flushes and closes the closers, returning the first error
+func (s *Server) close() error {
+	var err error
+	for _, c := range s.Closers {
+		if f, ok := c.(interface{ Flush() error }); ok {
+			if ferr := f.Flush(); err == nil {
+				err = ferr
+			}
+		}
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package advhttp
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ServerTimingHeader = "Server-Timing"
+)
+
+// A ServerTiming collects the named timing spans recorded while serving a
+// request (db, cache, upstream, etc) so they can be sent to the client in the
+// Server-Timing header.
+type ServerTiming struct {
+	mu      sync.Mutex
+	metrics []serverTimingMetric
+}
+
+type serverTimingMetric struct {
+	name        string
+	description string
+	duration    time.Duration
+}
+
+// Records a span that took duration. The description is optional.
+func (st *ServerTiming) Add(name string, description string, duration time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.metrics = append(st.metrics, serverTimingMetric{name: name, description: description, duration: duration})
+}
+
+// Starts a span, call the returned function when it is done, eg:
+//
+//	defer st.Start("db", "")()
+func (st *ServerTiming) Start(name string, description string) func() {
+	start := time.Now()
+	return func() {
+		st.Add(name, description, time.Now().Sub(start))
+	}
+}
+
+// Formats the recorded spans as a Server-Timing header value, eg:
+// db;dur=53.2, cache;desc="Cache Read";dur=23.2
+func (st *ServerTiming) String() string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	parts := make([]string, 0, len(st.metrics))
+	for _, m := range st.metrics {
+		part := m.name
+		if m.description != "" {
+			part += ";desc=" + strconv.Quote(m.description)
+		}
+		part += ";dur=" + strconv.FormatFloat(float64(m.duration)/float64(time.Millisecond), 'f', -1, 64)
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+type serverTimingContextKey struct{}
+
+// Returns the ServerTiming on the context, or nil if there isn't one.
+func ServerTimingFromContext(ctx context.Context) *ServerTiming {
+	st, _ := ctx.Value(serverTimingContextKey{}).(*ServerTiming)
+	return st
+}
+
+// Records a span on the request's ServerTiming. It does nothing if the request
+// didn't come through a server timing handler.
+func AddServerTiming(r *http.Request, name string, description string, duration time.Duration) {
+	if st := ServerTimingFromContext(r.Context()); st != nil {
+		st.Add(name, description, duration)
+	}
+}
+
+// Starts a span on the request's ServerTiming, call the returned function when
+// it is done:
+//
+//	defer advhttp.StartServerTiming(r, "db", "")()
+func StartServerTiming(r *http.Request, name string, description string) func() {
+	if st := ServerTimingFromContext(r.Context()); st != nil {
+		return st.Start(name, description)
+	}
+	return func() {}
+}
+
+// Returns a handler that puts a ServerTiming on the request context for inner
+// handlers to record spans on. Just before the headers are sent the spans, and
+// a total span for the time spent so far, are written to the Server-Timing
+// header using a UtilResponseWriter.
+func NewServerTimingHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		st := new(ServerTiming)
+		urw := NewUtilResponseWriter(w, func(headers http.Header) {
+			value := st.String()
+			total := "total;dur=" + strconv.FormatFloat(float64(time.Now().Sub(start))/float64(time.Millisecond), 'f', -1, 64)
+			if value != "" {
+				value += ", "
+			}
+			headers.Add(ServerTimingHeader, value+total)
+		})
+		h.ServeHTTP(urw, r.WithContext(context.WithValue(r.Context(), serverTimingContextKey{}, st)))
+		// Empty responses still get the header, unless the connection was hijacked
+		if !urw.sentHeaders {
+			urw.WriteHeader(http.StatusOK)
+		}
+	})
+}
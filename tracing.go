@@ -0,0 +1,143 @@
+package advhttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+
+	TraceFlagSampled = byte(0x01)
+)
+
+var (
+	ErrInvalidTraceparent = errors.New("Invalid traceparent header")
+)
+
+// A TraceContext is the W3C trace context for the span serving a request. The
+// TraceID is shared by every span in the trace, SpanID identifies this span and
+// ParentID the span that called us (empty if we started the trace).
+type TraceContext struct {
+	TraceID    string
+	ParentID   string
+	SpanID     string
+	Flags      byte
+	TraceState string
+}
+
+// Returns a new TraceContext starting a new, sampled, trace.
+func NewTraceContext() *TraceContext {
+	return &TraceContext{TraceID: randomHex(16), SpanID: randomHex(8), Flags: TraceFlagSampled}
+}
+
+// Parses a traceparent header (and the optional tracestate header) into the
+// TraceContext of the caller's span.
+func ParseTraceparent(traceparent string, tracestate string) (*TraceContext, error) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || !isLowerHex(parts[0], 2) || parts[0] == "ff" ||
+		!isLowerHex(parts[1], 32) || parts[1] == strings.Repeat("0", 32) ||
+		!isLowerHex(parts[2], 16) || parts[2] == strings.Repeat("0", 16) ||
+		!isLowerHex(parts[3], 2) {
+		return nil, ErrInvalidTraceparent
+	}
+	// Version 00 has exactly four fields, future versions may add more
+	if parts[0] == "00" && len(parts) != 4 {
+		return nil, ErrInvalidTraceparent
+	}
+	flags, _ := hex.DecodeString(parts[3])
+	return &TraceContext{TraceID: parts[1], SpanID: parts[2], Flags: flags[0], TraceState: strings.TrimSpace(tracestate)}, nil
+}
+
+// Returns a new span in the same trace, whose parent is this span.
+func (tc *TraceContext) Child() *TraceContext {
+	return &TraceContext{TraceID: tc.TraceID, ParentID: tc.SpanID, SpanID: randomHex(8), Flags: tc.Flags, TraceState: tc.TraceState}
+}
+
+// Returns whether the caller has recorded the trace.
+func (tc *TraceContext) Sampled() bool {
+	return tc.Flags&TraceFlagSampled != 0
+}
+
+// Returns the traceparent header value identifying this span.
+func (tc *TraceContext) Traceparent() string {
+	return "00-" + tc.TraceID + "-" + tc.SpanID + "-" + hex.EncodeToString([]byte{tc.Flags})
+}
+
+// Sets the traceparent and tracestate headers so that the request to an
+// upstream carries this span as its parent.
+func (tc *TraceContext) Inject(h http.Header) {
+	h.Set(TraceparentHeader, tc.Traceparent())
+	if tc.TraceState != "" {
+		h.Set(TracestateHeader, tc.TraceState)
+	} else {
+		h.Del(TracestateHeader)
+	}
+}
+
+type traceContextKey struct{}
+
+// Returns a copy of the context carrying the trace context.
+func ContextWithTraceContext(ctx context.Context, tc *TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// Returns the trace context on the context, or nil if there isn't one.
+func TraceContextFromContext(ctx context.Context) *TraceContext {
+	tc, _ := ctx.Value(traceContextKey{}).(*TraceContext)
+	return tc
+}
+
+// Sets the traceparent and tracestate headers from the trace context on ctx,
+// if there is one. Use it on requests to other services so the trace follows.
+func InjectTraceContext(ctx context.Context, h http.Header) {
+	if tc := TraceContextFromContext(ctx); tc != nil {
+		tc.Inject(h)
+	}
+}
+
+// Returns a handler that continues the trace from the incoming traceparent
+// header with a new span, or starts a new trace if the header is missing or
+// invalid, and puts it on the request context. The logging handlers include
+// the trace id, and AddOutboundHeaders passes the trace on to upstreams.
+func NewTracingHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tc *TraceContext
+		if parent, err := ParseTraceparent(r.Header.Get(TraceparentHeader), r.Header.Get(TracestateHeader)); err == nil {
+			tc = parent.Child()
+		} else {
+			tc = NewTraceContext()
+		}
+		h.ServeHTTP(w, r.WithContext(ContextWithTraceContext(r.Context(), tc)))
+	})
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	for {
+		rand.Read(b)
+		for _, c := range b {
+			// All zero ids are invalid
+			if c != 0 {
+				return hex.EncodeToString(b)
+			}
+		}
+	}
+}
+
+func isLowerHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
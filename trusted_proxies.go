@@ -0,0 +1,182 @@
+package advhttp
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	// The trusted proxies set X-Forwarded-For, X-Forwarded-Proto and
+	// X-Forwarded-Host
+	TrustedProxiesXForwarded = iota
+	// The trusted proxies set the RFC 7239 Forwarded header
+	TrustedProxiesForwarded
+)
+
+var (
+	// The ranges trusted by DefaultTrustedProxies, loopback and private networks
+	TrustedProxiesDefaultCIDRs = []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"}
+)
+
+// TrustedProxies is a list of networks whose proxies are allowed to tell us who
+// the client is through the Forwarded and X-Forwarded-* headers. The headers
+// of a request are only believed as far back as the chain of trusted proxies
+// goes, so a client can't spoof it's ip or scheme by sending the headers itself.
+//
+// Only the family of headers the proxies set is used. Proxies pass the other
+// family through untouched, so a client could forge it.
+type TrustedProxies struct {
+	// Which headers the trusted proxies set, TrustedProxiesXForwarded (the
+	// default) or TrustedProxiesForwarded
+	Headers int
+
+	nets []*net.IPNet
+}
+
+// Returns a new TrustedProxies trusting the given CIDRs (eg 10.0.0.0/8). Plain
+// ip addresses are trusted as a single host.
+func NewTrustedProxies(cidrs ...string) (*TrustedProxies, error) {
+	tp := new(TrustedProxies)
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, errors.New("Invalid trusted proxy address: " + cidr)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		tp.nets = append(tp.nets, ipNet)
+	}
+	return tp, nil
+}
+
+// Returns whether the address (an ip, host:port, or Forwarded node) is a
+// trusted proxy.
+func (tp *TrustedProxies) Trusted(addr string) bool {
+	if tp == nil {
+		return false
+	}
+	ip := net.ParseIP(forwardedNodeIP(addr))
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range tp.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the ip of the client that made the request. The X-Forwarded-For (or
+// Forwarded, see Headers) header is walked from the right, starting with the
+// peer that connected to us, until an address that isn't a trusted proxy is
+// found.
+func (tp *TrustedProxies) RealIP(r *http.Request) string {
+	ip, _ := tp.resolve(r)
+	return ip
+}
+
+// Returns the scheme (http or https) the client used to make the request. The
+// forwarded proto is only used if it came from a trusted proxy.
+func (tp *TrustedProxies) RealProto(r *http.Request) string {
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	if _, hop := tp.resolve(r); hop >= 0 {
+		if tp.Headers == TrustedProxiesForwarded {
+			if fwds := ParseForwarded(r.Header); hop < len(fwds) && fwds[hop].Proto != "" {
+				proto = strings.ToLower(fwds[hop].Proto)
+			}
+		} else if xfp := firstHeaderValue(r.Header.Get("X-Forwarded-Proto")); xfp != "" {
+			proto = strings.ToLower(xfp)
+		}
+	}
+	return proto
+}
+
+// Returns the host the client made the request to. The forwarded host is only
+// used if it came from a trusted proxy.
+func (tp *TrustedProxies) RealHost(r *http.Request) string {
+	host := r.Host
+	if _, hop := tp.resolve(r); hop >= 0 {
+		if tp.Headers == TrustedProxiesForwarded {
+			if fwds := ParseForwarded(r.Header); hop < len(fwds) && fwds[hop].Host != "" {
+				host = fwds[hop].Host
+			}
+		} else if xfh := firstHeaderValue(r.Header.Get("X-Forwarded-Host")); xfh != "" {
+			host = xfh
+		}
+	}
+	return host
+}
+
+// Walks the forwarding chain, returning the client ip and the index of the
+// hop in the chain that reported it, or -1 if it is the peer itself.
+func (tp *TrustedProxies) resolve(r *http.Request) (string, int) {
+	ip := forwardedNodeIP(r.RemoteAddr)
+	if !tp.Trusted(ip) {
+		return ip, -1
+	}
+
+	var chain []string
+	if tp.Headers == TrustedProxiesForwarded {
+		for _, fwd := range ParseForwarded(r.Header) {
+			chain = append(chain, fwd.ForIP())
+		}
+	} else {
+		for _, value := range r.Header["X-Forwarded-For"] {
+			for _, fwd := range strings.Split(value, ",") {
+				chain = append(chain, strings.TrimSpace(fwd))
+			}
+		}
+	}
+	if len(chain) == 0 {
+		return ip, -1
+	}
+	known := ip
+	for i := len(chain) - 1; i >= 0; i-- {
+		// A trusted proxy that only sent proto or host, without for, didn't say
+		// who connected to it, so the last address we know of is the client
+		if chain[i] == "" {
+			return known, i
+		}
+		if !tp.Trusted(chain[i]) {
+			return chain[i], i
+		}
+		known = chain[i]
+	}
+	return chain[0], 0
+}
+
+func firstHeaderValue(value string) string {
+	if i := strings.Index(value, ","); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
+
+var DefaultTrustedProxies *TrustedProxies
+
+func RealIP(r *http.Request) string {
+	return DefaultTrustedProxies.RealIP(r)
+}
+
+func RealProto(r *http.Request) string {
+	return DefaultTrustedProxies.RealProto(r)
+}
+
+func RealHost(r *http.Request) string {
+	return DefaultTrustedProxies.RealHost(r)
+}
@@ -0,0 +1,52 @@
+package advhttp
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxiesResolve(t *testing.T) {
+	tests := []struct {
+		name       string
+		headers    int
+		remoteAddr string
+		xff        string
+		xfp        string
+		forwarded  string
+		ip         string
+		proto      string
+	}{
+		{name: "untrusted peer", remoteAddr: "198.51.100.1:1234", xff: "203.0.113.9", xfp: "https", ip: "198.51.100.1", proto: "http"},
+		{name: "trusted peer", remoteAddr: "10.0.0.2:1234", xff: "203.0.113.9", xfp: "https", ip: "203.0.113.9", proto: "https"},
+		{name: "spoofed x-forwarded-for", remoteAddr: "10.0.0.2:1234", xff: "6.6.6.6, 203.0.113.9", ip: "203.0.113.9", proto: "http"},
+		{name: "forwarded ignored", remoteAddr: "10.0.0.2:1234", xff: "203.0.113.9", forwarded: "for=6.6.6.6;proto=https", ip: "203.0.113.9", proto: "http"},
+		{name: "forwarded", headers: TrustedProxiesForwarded, remoteAddr: "10.0.0.2:1234", forwarded: "for=203.0.113.9;proto=https", ip: "203.0.113.9", proto: "https"},
+		{name: "x-forwarded ignored", headers: TrustedProxiesForwarded, remoteAddr: "10.0.0.2:1234", xff: "6.6.6.6", xfp: "https", ip: "10.0.0.2", proto: "http"},
+		{name: "spoofed forwarded", headers: TrustedProxiesForwarded, remoteAddr: "10.0.0.2:1234", forwarded: "for=6.6.6.6, for=203.0.113.9", ip: "203.0.113.9", proto: "http"},
+		{name: "forwarded without for", headers: TrustedProxiesForwarded, remoteAddr: "10.0.0.2:1234", forwarded: "proto=https", ip: "10.0.0.2", proto: "https"},
+	}
+	for _, test := range tests {
+		tp, err := NewTrustedProxies(TrustedProxiesDefaultCIDRs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tp.Headers = test.headers
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.xff != "" {
+			r.Header.Set("X-Forwarded-For", test.xff)
+		}
+		if test.xfp != "" {
+			r.Header.Set("X-Forwarded-Proto", test.xfp)
+		}
+		if test.forwarded != "" {
+			r.Header.Set(ForwardedHeader, test.forwarded)
+		}
+		if ip := tp.RealIP(r); ip != test.ip {
+			t.Errorf("%v: expected ip %v, got %v", test.name, test.ip, ip)
+		}
+		if proto := tp.RealProto(r); proto != test.proto {
+			t.Errorf("%v: expected proto %v, got %v", test.name, test.proto, proto)
+		}
+	}
+}
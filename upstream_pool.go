@@ -0,0 +1,254 @@
+package advhttp
+
+import (
+	"errors"
+	"hash/crc32"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	BalanceRoundRobin       = "round_robin"
+	BalanceLeastConnections = "least_connections"
+	BalanceConsistentHash   = "consistent_hash"
+)
+
+var (
+	// The number of points each upstream gets on the consistent hash ring
+	UpstreamPoolDefaultReplicas = 100
+	// How long a passively ejected upstream stays out of a pool that has no
+	// FailTimeout and no health checks to re-admit it
+	UpstreamPoolDefaultFailTimeout = 10 * time.Second
+
+	ErrNoUpstreams = errors.New("No upstreams available")
+)
+
+// An Upstream is a single backend instance within an UpstreamPool. It tracks
+// the number of requests that are currently in flight to it, and whether or
+// not it is healthy enough to be sent traffic.
+type Upstream struct {
+	URL *url.URL
+
+	active int64
+
+	mu       sync.Mutex
+	down     bool
+	downTill time.Time
+	failures int
+}
+
+// Returns the number of requests currently being proxied to the upstream.
+func (u *Upstream) Active() int64 {
+	return atomic.LoadInt64(&u.active)
+}
+
+// Returns whether the upstream is currently accepting traffic. An upstream that
+// was ejected with a timeout is re-admitted once the timeout has passed.
+func (u *Upstream) Healthy() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.down && !u.downTill.IsZero() && time.Now().After(u.downTill) {
+		u.down = false
+		u.failures = 0
+	}
+	return !u.down
+}
+
+// Marks the upstream as healthy, re-admitting it to the pool.
+func (u *Upstream) MarkHealthy() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.down = false
+	u.downTill = time.Time{}
+	u.failures = 0
+}
+
+// Ejects the upstream from the pool. If timeout is non zero the upstream will be
+// re-admitted automatically after it passes, otherwise it stays down until it
+// is marked healthy again (usually by a health check).
+func (u *Upstream) MarkUnhealthy(timeout time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.down = true
+	u.downTill = time.Time{}
+	if timeout != 0 {
+		u.downTill = time.Now().Add(timeout)
+	}
+}
+
+// The UpstreamPool is a set of backend instances for a single route that the
+// reverse proxy can spread requests across. The Balance policy selects how an
+// upstream is picked for each request:
+//
+// - BalanceRoundRobin (the default) cycles through the upstreams in order
+// - BalanceLeastConnections picks the upstream with the fewest active requests
+// - BalanceConsistentHash hashes the HashHeader value (or the client ip, see
+// RealIP, if the header is not set or empty) so the same client sticks to an
+// upstream
+//
+// Upstreams that are unhealthy are skipped. An upstream is ejected passively
+// after MaxFails consecutive 5xx responses or connection errors, and actively
+// by the health checks started with StartHealthChecks.
+type UpstreamPool struct {
+	Balance    string
+	HashHeader string
+	// The number of consecutive failures before an upstream is ejected, zero
+	// disables passive ejection
+	MaxFails int
+	// How long a passively ejected upstream stays out of the pool, zero keeps
+	// it out until a health check re-admits it (or for
+	// UpstreamPoolDefaultFailTimeout if health checks aren't running)
+	FailTimeout time.Duration
+
+	mu        sync.RWMutex
+	upstreams []*Upstream
+	ring      []uint32
+	ringMap   map[uint32]*Upstream
+	next      uint64
+	stop      chan struct{}
+}
+
+// Returns a new pool using the given balance policy across the targets. An
+// empty policy is BalanceRoundRobin, anything other than the Balance constants
+// is an error.
+func NewUpstreamPool(balance string, targets ...*url.URL) (*UpstreamPool, error) {
+	switch balance {
+	case "", BalanceRoundRobin, BalanceLeastConnections, BalanceConsistentHash:
+	default:
+		return nil, errors.New("Unknown balance policy: " + balance)
+	}
+	pool := new(UpstreamPool)
+	pool.Balance = balance
+	for _, target := range targets {
+		pool.Add(target)
+	}
+	return pool, nil
+}
+
+// Adds a new upstream to the pool and returns it.
+func (pool *UpstreamPool) Add(target *url.URL) *Upstream {
+	u := &Upstream{URL: target}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.upstreams = append(pool.upstreams, u)
+	pool.buildRing()
+	return u
+}
+
+// Removes the upstream with the given url from the pool.
+func (pool *UpstreamPool) Remove(target *url.URL) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for i, u := range pool.upstreams {
+		if u.URL.String() == target.String() {
+			pool.upstreams = append(pool.upstreams[:i], pool.upstreams[i+1:]...)
+			break
+		}
+	}
+	pool.buildRing()
+}
+
+// Returns a copy of the list of upstreams in the pool.
+func (pool *UpstreamPool) Upstreams() []*Upstream {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	return append([]*Upstream(nil), pool.upstreams...)
+}
+
+// Picks the upstream that should serve the request according to the pool's
+// balance policy. ErrNoUpstreams is returned if the pool is empty or none of
+// the upstreams are healthy.
+func (pool *UpstreamPool) Next(r *http.Request) (*Upstream, error) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	healthy := make([]*Upstream, 0, len(pool.upstreams))
+	for _, u := range pool.upstreams {
+		if u.Healthy() {
+			healthy = append(healthy, u)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil, ErrNoUpstreams
+	}
+
+	switch pool.Balance {
+	case BalanceLeastConnections:
+		var best *Upstream
+		for _, u := range healthy {
+			if best == nil || u.Active() < best.Active() {
+				best = u
+			}
+		}
+		return best, nil
+	case BalanceConsistentHash:
+		key := ""
+		if pool.HashHeader != "" {
+			key = r.Header.Get(pool.HashHeader)
+		}
+		// Behind a load balancer the peer is the same for every client
+		if key == "" {
+			key = forwardedNodeIP(RealIP(r))
+		}
+		h := crc32.ChecksumIEEE([]byte(key))
+		i := sort.Search(len(pool.ring), func(i int) bool { return pool.ring[i] >= h })
+		//Walk the ring clockwise until we find a healthy upstream
+		for j := 0; j < len(pool.ring); j++ {
+			u := pool.ringMap[pool.ring[(i+j)%len(pool.ring)]]
+			if u.Healthy() {
+				return u, nil
+			}
+		}
+		return nil, ErrNoUpstreams
+	default:
+		n := atomic.AddUint64(&pool.next, 1) - 1
+		return healthy[n%uint64(len(healthy))], nil
+	}
+}
+
+// Records the outcome of a request sent to the upstream. Once MaxFails
+// consecutive failures have been reported the upstream is ejected for
+// FailTimeout. A success resets the failure count.
+func (pool *UpstreamPool) ReportResult(u *Upstream, success bool) {
+	u.mu.Lock()
+	if success {
+		u.failures = 0
+		u.mu.Unlock()
+		return
+	}
+	u.failures++
+	eject := pool.MaxFails > 0 && u.failures >= pool.MaxFails && !u.down
+	u.mu.Unlock()
+	if !eject {
+		return
+	}
+	timeout := pool.FailTimeout
+	pool.mu.RLock()
+	checked := pool.stop != nil
+	pool.mu.RUnlock()
+	// Without health checks nothing would ever re-admit it
+	if timeout == 0 && !checked {
+		timeout = UpstreamPoolDefaultFailTimeout
+	}
+	u.MarkUnhealthy(timeout)
+}
+
+func (pool *UpstreamPool) buildRing() {
+	pool.ring = make([]uint32, 0, len(pool.upstreams)*UpstreamPoolDefaultReplicas)
+	pool.ringMap = make(map[uint32]*Upstream, len(pool.upstreams)*UpstreamPoolDefaultReplicas)
+	for _, u := range pool.upstreams {
+		for i := 0; i < UpstreamPoolDefaultReplicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + u.URL.String()))
+			if _, ok := pool.ringMap[h]; ok {
+				continue
+			}
+			pool.ring = append(pool.ring, h)
+			pool.ringMap[h] = u
+		}
+	}
+	sort.Slice(pool.ring, func(i, j int) bool { return pool.ring[i] < pool.ring[j] })
+}
@@ -3,6 +3,7 @@ package advhttp
 import (
 	"bufio"
 	"errors"
+	"io"
 	"net"
 	"net/http"
 	"path"
@@ -33,55 +34,172 @@ func NewRPResponseWriter(w http.ResponseWriter, prefix string, via string) *Util
 
 func NewRewriteLocationHeaderCallback(prefix string) func(http.Header) {
 	return func(headers http.Header) {
-		oldLocation := headers.Get("Location")
-		if oldLocation != "" {
-			if strings.HasPrefix(oldLocation, "/") {
-				if strings.HasSuffix(oldLocation, "/") && oldLocation != "/" {
-					headers.Set("Location", path.Join(prefix, oldLocation)+"/")
-				} else {
-					headers.Set("Location", path.Join(prefix, oldLocation))
-				}
-			}
+		if location := headers.Get("Location"); location != "" {
+			headers.Set("Location", prefixPath(prefix, location))
 		}
 	}
 }
 
+// Returns a callback that will re-write the Location, Content-Location and
+// Refresh headers, as well as the Path and Domain of any cookies so that they
+// work through the prefix. The via string is added to the Via header.
 func NewReverseProxyHeadersCallback(prefix string, via string) func(http.Header) {
-	return func(headers http.Header) {
-		oldLocation := headers.Get("Location")
-		if oldLocation != "" {
-			if strings.HasPrefix(oldLocation, "/") {
-				if strings.HasSuffix(oldLocation, "/") && oldLocation != "/" {
-					headers.Set("Location", path.Join(prefix, oldLocation)+"/")
-				} else {
-					headers.Set("Location", path.Join(prefix, oldLocation))
-				}
-			}
+	return NewHeaderRewriter(prefix, via).Callback
+}
+
+// The HeaderRewriter re-writes the headers of a reverse proxied response so
+// that urls and cookies from the upstream server work through the gateway
+// prefix the upstream is mounted on.
+type HeaderRewriter struct {
+	// The path prepended to root relative urls and cookie paths
+	Prefix string
+	// Added to the Via header, unless it is empty
+	Via string
+	// The headers containing a url that will be re-written, the Refresh header
+	// is understood to have the url after the delay
+	URLHeaders []string
+	// Whether to prepend the prefix to the Path attribute of Set-Cookie headers
+	RewriteCookiePath bool
+	// Maps the Domain attribute of Set-Cookie headers (without leading dot) to
+	// the domain it should be replaced with. An empty replacement removes the
+	// attribute, making the cookie belong to the gateway host. The "*" key
+	// matches any domain.
+	CookieDomains map[string]string
+}
+
+var (
+	HeaderRewriterDefaultURLHeaders = []string{"Location", "Content-Location", "Refresh"}
+)
+
+// Returns a new HeaderRewriter with the default settings, re-writing all the
+// url headers, cookie paths and removing cookie domains.
+func NewHeaderRewriter(prefix string, via string) *HeaderRewriter {
+	hr := new(HeaderRewriter)
+	hr.Prefix = prefix
+	hr.Via = via
+	hr.URLHeaders = HeaderRewriterDefaultURLHeaders
+	hr.RewriteCookiePath = true
+	hr.CookieDomains = map[string]string{"*": ""}
+	return hr
+}
+
+// Re-writes the headers, can be used as a UtilResponseWriter SendHeadersCallback
+func (hr *HeaderRewriter) Callback(headers http.Header) {
+	for _, h := range hr.URLHeaders {
+		value := headers.Get(h)
+		if value == "" {
+			continue
 		}
+		if http.CanonicalHeaderKey(h) == "Refresh" {
+			headers.Set(h, hr.rewriteRefresh(value))
+		} else {
+			headers.Set(h, prefixPath(hr.Prefix, value))
+		}
+	}
+
+	if cookies, ok := headers["Set-Cookie"]; ok && (hr.RewriteCookiePath || len(hr.CookieDomains) > 0) {
+		for i, cookie := range cookies {
+			cookies[i] = hr.rewriteCookie(cookie)
+		}
+	}
+
+	if hr.Via != "" {
 		if headers.Get("Via") == "" {
-			headers.Set("Via", via)
+			headers.Set("Via", hr.Via)
 		} else {
-			headers.Set("Via", via+", "+headers.Get("Via"))
+			headers.Set("Via", hr.Via+", "+headers.Get("Via"))
 		}
 	}
 }
 
+// Refresh looks like `5; url=/some/path`
+func (hr *HeaderRewriter) rewriteRefresh(value string) string {
+	i := strings.Index(strings.ToLower(value), "url=")
+	if i < 0 {
+		return value
+	}
+	u := strings.TrimSpace(value[i+4:])
+	quote := ""
+	if len(u) >= 2 && (u[0] == '\'' || u[0] == '"') && u[len(u)-1] == u[0] {
+		quote = u[:1]
+		u = u[1 : len(u)-1]
+	}
+	return value[:i+4] + quote + prefixPath(hr.Prefix, u) + quote
+}
+
+func (hr *HeaderRewriter) rewriteCookie(cookie string) string {
+	parts := strings.Split(cookie, ";")
+	out := parts[:1]
+	for _, part := range parts[1:] {
+		attr := strings.TrimSpace(part)
+		name, value := attr, ""
+		if i := strings.Index(attr, "="); i >= 0 {
+			name, value = strings.TrimSpace(attr[:i]), strings.TrimSpace(attr[i+1:])
+		}
+		switch strings.ToLower(name) {
+		case "path":
+			if hr.RewriteCookiePath {
+				part = " " + name + "=" + prefixPath(hr.Prefix, value)
+			}
+		case "domain":
+			replacement, ok := hr.CookieDomains[strings.ToLower(strings.TrimPrefix(value, "."))]
+			if !ok {
+				replacement, ok = hr.CookieDomains["*"]
+			}
+			if ok && replacement == "" {
+				continue
+			}
+			if ok {
+				part = " " + name + "=" + replacement
+			}
+		}
+		out = append(out, part)
+	}
+	return strings.Join(out, ";")
+}
+
+// Prepends the prefix to root relative urls, leaving absolute and relative
+// urls alone. A trailing slash and any query or fragment are preserved.
+func prefixPath(prefix string, u string) string {
+	if !strings.HasPrefix(u, "/") || strings.HasPrefix(u, "//") {
+		return u
+	}
+	p, rest := u, ""
+	if i := strings.IndexAny(u, "?#"); i >= 0 {
+		p, rest = u[:i], u[i:]
+	}
+	if strings.HasSuffix(p, "/") && p != "/" {
+		return path.Join(prefix, p) + "/" + rest
+	}
+	return path.Join(prefix, p) + rest
+}
+
 func (urw *UtilResponseWriter) Header() http.Header {
 	return urw.w.Header()
 }
 
 func (urw *UtilResponseWriter) WriteHeader(status int) {
-	urw.SendHeadersCallback(urw.Header())
-	urw.sentHeaders = true
+	// Informational responses don't commit the headers
+	if status >= 200 {
+		urw.sendHeaders()
+	}
 	urw.w.WriteHeader(status)
 }
 
 func (urw *UtilResponseWriter) Write(bytes []byte) (int, error) {
-	if !urw.sentHeaders {
+	urw.sendHeaders()
+	return urw.w.Write(bytes)
+}
+
+// Calls the callback the first time the headers are about to be sent
+func (urw *UtilResponseWriter) sendHeaders() {
+	if urw.sentHeaders {
+		return
+	}
+	urw.sentHeaders = true
+	if urw.SendHeadersCallback != nil {
 		urw.SendHeadersCallback(urw.Header())
-		urw.sentHeaders = true
 	}
-	return urw.w.Write(bytes)
 }
 
 func (urw *UtilResponseWriter) GetFlusher() (flusher http.Flusher, ok bool) {
@@ -90,11 +208,39 @@ func (urw *UtilResponseWriter) GetFlusher() (flusher http.Flusher, ok bool) {
 }
 
 func (urw *UtilResponseWriter) Flush() {
+	urw.sendHeaders()
 	if flusher, ok := urw.w.(http.Flusher); ok {
 		flusher.Flush()
 	}
 }
 
+// Sends the headers and then copies src to the underlying writer using it's
+// io.ReaderFrom, so that sendfile is still used for files.
+func (urw *UtilResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	urw.sendHeaders()
+	if rf, ok := urw.w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	return io.Copy(writerOnly{urw.w}, src)
+}
+
+func (urw *UtilResponseWriter) GetPusher() (pusher http.Pusher, ok bool) {
+	pusher, ok = urw.w.(http.Pusher)
+	return
+}
+
+func (urw *UtilResponseWriter) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := urw.w.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Returns the wrapped http.ResponseWriter, for use by http.ResponseController
+func (urw *UtilResponseWriter) Unwrap() http.ResponseWriter {
+	return urw.w
+}
+
 func (urw *UtilResponseWriter) GetHijacker() (hijacker http.Hijacker, ok bool) {
 	hijacker, ok = urw.w.(http.Hijacker)
 	return
@@ -102,6 +248,8 @@ func (urw *UtilResponseWriter) GetHijacker() (hijacker http.Hijacker, ok bool) {
 
 func (urw *UtilResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if hijacker, ok := urw.w.(http.Hijacker); ok {
+		// The headers will never be sent through us after this
+		urw.sentHeaders = true
 		return hijacker.Hijack()
 	}
 	return nil, nil, errors.New("Couldn't cast responsewriter to hijacker")